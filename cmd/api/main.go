@@ -18,35 +18,221 @@
 package main
 
 import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/gorm"
 	_ "movie-api/docs"
 	"movie-api/internal/auth"
+	"movie-api/internal/breach"
+	"movie-api/internal/config"
 	"movie-api/internal/database"
 	"movie-api/internal/handlers"
+	"movie-api/internal/mail"
+	"movie-api/internal/models"
+	"movie-api/internal/search"
 )
 
 func main() {
+	cfg := config.Load()
 	db := database.InitDB()
-	
+
+	index, err := search.Setup(db)
+	if err != nil {
+		log.Fatalf("failed to set up the search index: %v", err)
+	}
+	if !index.FTS5() {
+		log.Println("search: SQLite was built without FTS5, using FTS4 (build with -tags sqlite_fts5)")
+	}
+	suggester := search.NewSuggester(db)
+	if err := suggester.Load(); err != nil {
+		log.Fatalf("failed to build the autocomplete index: %v", err)
+	}
+	if err := suggester.Watch(); err != nil {
+		log.Fatalf("failed to watch for autocomplete updates: %v", err)
+	}
+
+	keys, err := auth.NewKeyManagerFromConfig(cfg.JWT)
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
+	}
+	auth.SetKeyManager(keys)
+	auth.SetLoginPolicy(cfg.Login)
+	auth.SetPasswordHasher(auth.NewPasswordHasher(cfg.Password))
+	if cfg.Password.BreachedList != "" {
+		breached, err := breach.Open(cfg.Password.BreachedList)
+		if err != nil {
+			log.Fatalf("failed to open breached password list: %v", err)
+		}
+		auth.SetPasswordPolicy(auth.NewPasswordPolicy(cfg.Password, breached))
+		go reloadBreachedListOnHangup(breached)
+	} else {
+		auth.SetPasswordPolicy(auth.NewPasswordPolicy(cfg.Password))
+	}
+	go reloadKeysOnHangup(keys)
+	go pruneTokensPeriodically(db)
+	go purgeDeletedAccountsPeriodically(db, cfg.DeletionGracePeriod)
+
+	if cfg.AdminUsername != "" {
+		if err := auth.EnsureAdmin(db, cfg.AdminUsername); err != nil {
+			log.Fatalf("failed to bootstrap admin: %v", err)
+		}
+	}
+
+	mailer := mail.NewMailerFromConfig(cfg.Mail)
+
 	r := gin.Default()
 	r.SetTrustedProxies(nil)
 
 	r.POST("/api/token", auth.LoginHandler(db))
-	r.POST("/api/users", auth.CreateUser(db)) 
+	r.POST("/api/token/refresh", auth.RefreshHandler(db))
+	r.POST("/api/token/mfa", auth.MFALoginHandler(db))
+	r.POST("/api/users", auth.CreateUser(db, mailer))
+	r.POST("/api/users/verify-email", auth.VerifyEmail(db))
+	r.POST("/api/password-reset", auth.RequestPasswordReset(db, mailer))
+	r.POST("/api/password-reset/confirm", auth.ConfirmPasswordReset(db))
+	r.GET("/api/.well-known/jwks.json", auth.JWKSHandler())
+	if cfg.OIDC.Issuer != "" {
+		provider := auth.NewOIDCProvider(cfg.OIDC)
+		r.GET("/api/oidc/login", auth.OIDCLogin(db, provider))
+		r.GET("/api/oidc/callback", auth.OIDCCallback(db, provider))
+	}
 	r.GET("/api/movies", handlers.GetMovies(db))
+	r.GET("/api/movies/autocomplete", handlers.Autocomplete(suggester))
 	r.GET("/api/movies/:id/", handlers.GetMovieDetails(db))
+	r.GET("/api/movies/:id/cast", handlers.GetCast(db))
+	r.GET("/api/movies/:id/crew", handlers.GetCrew(db))
+	r.GET("/api/genres", handlers.ListReferences(db, handlers.GenreTable))
+	r.GET("/api/genres/:id", handlers.GetReference(db, handlers.GenreTable))
+	r.GET("/api/people", handlers.ListReferences(db, handlers.PersonTable))
+	r.GET("/api/people/:id", handlers.GetReference(db, handlers.PersonTable))
+	r.GET("/api/countries", handlers.ListReferences(db, handlers.CountryTable))
+	r.GET("/api/countries/:id", handlers.GetReference(db, handlers.CountryTable))
+	r.GET("/api/languages", handlers.ListReferences(db, handlers.LanguageTable))
+	r.GET("/api/languages/:id", handlers.GetReference(db, handlers.LanguageTable))
 	r.GET("/api/reviews", handlers.GetReviews(db))
 	r.GET("/api/reviews/:id/", handlers.GetReviewDetails(db))
+	r.GET("/api/users/:username", handlers.GetUserProfile(db))
+	r.GET("/api/search", handlers.Search(index))
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	authGroup := r.Group("/")
 	authGroup.Use(auth.JWTAuthMiddleware(db))
 	{
-		authGroup.POST("/api/reviews", handlers.CreateReview(db))
-		authGroup.POST("/api/movies", handlers.CreateMovie(db))
+		authGroup.POST("/api/reviews", auth.RequireScope(auth.ScopeReviewsWrite), auth.RequireVerifiedEmail(), handlers.CreateReview(db))
+		authGroup.POST("/api/movies", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateMovie(db))
+		authGroup.PUT("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.ReplaceMovie(db))
+		authGroup.PATCH("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.PatchMovie(db))
+		authGroup.DELETE("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteMovie(db))
+		authGroup.POST("/api/movies/:id/restore", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RestoreMovie(db))
+		authGroup.POST("/api/movies/:id/cast", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.AddCastCredit(db))
+		authGroup.PUT("/api/movies/:id/cast", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.ReorderCast(db))
+		authGroup.PATCH("/api/movies/:id/cast/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateCastCredit(db))
+		authGroup.DELETE("/api/movies/:id/cast/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RemoveCastCredit(db))
+		authGroup.POST("/api/movies/:id/crew", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.AddCrewCredit(db))
+		authGroup.PATCH("/api/movies/:id/crew/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateCrewCredit(db))
+		authGroup.DELETE("/api/movies/:id/crew/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RemoveCrewCredit(db))
+		authGroup.POST("/api/genres", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.GenreTable))
+		authGroup.PUT("/api/genres/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.GenreTable))
+		authGroup.DELETE("/api/genres/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.GenreTable))
+		authGroup.POST("/api/people", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.PersonTable))
+		authGroup.PUT("/api/people/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.PersonTable))
+		authGroup.DELETE("/api/people/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.PersonTable))
+		authGroup.POST("/api/countries", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.CountryTable))
+		authGroup.PUT("/api/countries/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.CountryTable))
+		authGroup.DELETE("/api/countries/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.CountryTable))
+		authGroup.POST("/api/languages", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.LanguageTable))
+		authGroup.PUT("/api/languages/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.LanguageTable))
+		authGroup.DELETE("/api/languages/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.LanguageTable))
+		authGroup.DELETE("/api/reviews/:id/", auth.RequireScope(auth.ScopeReviewsModerate), auth.RequireRole(models.RoleModerator), handlers.DeleteReview(db))
+		authGroup.GET("/api/users/me", handlers.GetMyProfile(db))
+	}
+
+	sessionGroup := authGroup.Group("/api")
+	sessionGroup.Use(auth.RequireSession())
+	{
+		sessionGroup.POST("/logout", auth.LogoutHandler(db))
+		sessionGroup.PATCH("/users/me", handlers.UpdateMyProfile(db, mailer))
+		sessionGroup.DELETE("/users/me", auth.DeleteAccount(db, cfg.DeletionGracePeriod))
+		sessionGroup.GET("/users/me/export", handlers.ExportMyData(db))
+		sessionGroup.POST("/users/me/verify-email", auth.ResendVerification(db, mailer))
+		sessionGroup.GET("/users/me/sessions", auth.ListSessions(db))
+		sessionGroup.DELETE("/users/me/sessions/:id", auth.RevokeSessionHandler(db))
+		sessionGroup.POST("/users/me/password", auth.ChangePassword(db))
+		sessionGroup.POST("/users/me/mfa/totp", auth.StartTOTPEnrollment(db))
+		sessionGroup.POST("/users/me/mfa/totp/verify", auth.ConfirmTOTPEnrollment(db))
+		sessionGroup.DELETE("/users/me/mfa/totp", auth.DisableTOTP(db))
+		sessionGroup.POST("/users/me/mfa/recovery-codes", auth.RegenerateRecoveryCodes(db))
+		sessionGroup.POST("/api-keys", auth.CreateAPIKey(db))
+		sessionGroup.GET("/api-keys", auth.ListAPIKeys(db))
+		sessionGroup.DELETE("/api-keys/:id", auth.RevokeAPIKey(db))
+	}
+
+	adminGroup := authGroup.Group("/api/admin")
+	adminGroup.Use(auth.RequireScope(auth.ScopeAdmin), auth.RequireRole(models.RoleAdmin))
+	{
+		adminGroup.PUT("/users/:id/role", auth.GrantRole(db))
+		adminGroup.DELETE("/users/:id/role", auth.RevokeRole(db))
+		adminGroup.POST("/users/:id/restore", auth.RestoreAccount(db))
+		adminGroup.POST("/service-accounts", auth.CreateServiceAccount(db))
+		adminGroup.GET("/lockouts", auth.ListLockouts(db))
+		adminGroup.POST("/unlock", auth.UnlockLogin(db))
+		adminGroup.GET("/audit", auth.ListAuthEvents(db))
+		adminGroup.DELETE("/movies/:id", handlers.PurgeMovie(db))
 	}
 
 	r.Run(":8000")
-}
\ No newline at end of file
+}
+
+// reloadKeysOnHangup re-reads the key file on SIGHUP so a new signing key
+// can be rolled out without restarting the server.
+func reloadKeysOnHangup(keys *auth.KeyManager) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := keys.Reload(); err != nil {
+			log.Printf("failed to reload JWT keys: %v", err)
+			continue
+		}
+		log.Println("JWT keys reloaded")
+	}
+}
+
+// reloadBreachedListOnHangup reopens the breached password list on SIGHUP,
+// after a new list was built and renamed into place.
+func reloadBreachedListOnHangup(list *breach.List) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := list.Reload(); err != nil {
+			log.Printf("failed to reload breached password list: %v", err)
+			continue
+		}
+		log.Printf("breached password list reloaded (%d entries)", list.Len())
+	}
+}
+
+func pruneTokensPeriodically(db *gorm.DB) {
+	for range time.Tick(time.Hour) {
+		if err := auth.PruneExpiredTokens(db); err != nil {
+			log.Printf("failed to prune expired tokens: %v", err)
+		}
+		if err := auth.PruneExpiredOIDCStates(db); err != nil {
+			log.Printf("failed to prune expired OIDC logins: %v", err)
+		}
+	}
+}
+
+func purgeDeletedAccountsPeriodically(db *gorm.DB, grace time.Duration) {
+	for range time.Tick(time.Hour) {
+		if err := auth.PurgeDeletedAccounts(db, grace); err != nil {
+			log.Printf("failed to purge deleted accounts: %v", err)
+		}
+	}
+}
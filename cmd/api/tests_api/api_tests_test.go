@@ -1,28 +1,62 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"movie-api/internal/auth"
+	"movie-api/internal/breach"
+	"movie-api/internal/config"
 	"movie-api/internal/database"
 	"movie-api/internal/handlers"
+	"movie-api/internal/mail"
+	"movie-api/internal/models"
+	"movie-api/internal/search"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"gorm.io/gorm"
 )
 
 var testDB *gorm.DB
+var testIndex *search.Index
+var testSuggester *search.Suggester
+var testMailer = &mail.MemoryMailer{}
 
 func TestMain(m *testing.M) {
     // Initialize once
     testDB = database.InitMockDB()
+    var err error
+    if testIndex, err = search.Setup(testDB); err != nil {
+        panic(err)
+    }
+    testSuggester = search.NewSuggester(testDB)
+    if err := testSuggester.Load(); err != nil {
+        panic(err)
+    }
+    if err := testSuggester.Watch(); err != nil {
+        panic(err)
+    }
     
     // Run tests
     code := m.Run()
@@ -40,23 +74,121 @@ func setupRouter() *gin.Engine {
     r.SetTrustedProxies(nil)
 
     r.POST("/api/token", auth.LoginHandler(db))
-    r.POST("/api/users", auth.CreateUser(db))
+    r.POST("/api/token/refresh", auth.RefreshHandler(db))
+    r.POST("/api/token/mfa", auth.MFALoginHandler(db))
+    r.POST("/api/users", auth.CreateUser(db, testMailer))
+    r.POST("/api/users/verify-email", auth.VerifyEmail(db))
+    r.POST("/api/password-reset", auth.RequestPasswordReset(db, testMailer))
+    r.POST("/api/password-reset/confirm", auth.ConfirmPasswordReset(db))
     r.GET("/api/movies", handlers.GetMovies(db))
+    r.GET("/api/movies/autocomplete", handlers.Autocomplete(testSuggester))
     r.GET("/api/movies/:id/", handlers.GetMovieDetails(db))
+    r.GET("/api/movies/:id/cast", handlers.GetCast(db))
+    r.GET("/api/movies/:id/crew", handlers.GetCrew(db))
+    r.GET("/api/genres", handlers.ListReferences(db, handlers.GenreTable))
+    r.GET("/api/genres/:id", handlers.GetReference(db, handlers.GenreTable))
+    r.GET("/api/people", handlers.ListReferences(db, handlers.PersonTable))
+    r.GET("/api/people/:id", handlers.GetReference(db, handlers.PersonTable))
+    r.GET("/api/countries", handlers.ListReferences(db, handlers.CountryTable))
+    r.GET("/api/countries/:id", handlers.GetReference(db, handlers.CountryTable))
+    r.GET("/api/languages", handlers.ListReferences(db, handlers.LanguageTable))
+    r.GET("/api/languages/:id", handlers.GetReference(db, handlers.LanguageTable))
     r.GET("/api/reviews", handlers.GetReviews(db))
     r.GET("/api/reviews/:id/", handlers.GetReviewDetails(db))
+    r.GET("/api/users/:username", handlers.GetUserProfile(db))
+    r.GET("/api/search", handlers.Search(testIndex))
     r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	authGroup := r.Group("/")
     authGroup.Use(auth.JWTAuthMiddleware(db))
     {
-        authGroup.POST("/api/reviews", handlers.CreateReview(db))
-        authGroup.POST("/api/movies", handlers.CreateMovie(db))
+        authGroup.POST("/api/reviews", auth.RequireScope(auth.ScopeReviewsWrite), auth.RequireVerifiedEmail(), handlers.CreateReview(db))
+        authGroup.POST("/api/movies", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateMovie(db))
+        authGroup.PUT("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.ReplaceMovie(db))
+        authGroup.PATCH("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.PatchMovie(db))
+        authGroup.DELETE("/api/movies/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteMovie(db))
+        authGroup.POST("/api/movies/:id/restore", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RestoreMovie(db))
+        authGroup.POST("/api/movies/:id/cast", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.AddCastCredit(db))
+        authGroup.PUT("/api/movies/:id/cast", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.ReorderCast(db))
+        authGroup.PATCH("/api/movies/:id/cast/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateCastCredit(db))
+        authGroup.DELETE("/api/movies/:id/cast/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RemoveCastCredit(db))
+        authGroup.POST("/api/movies/:id/crew", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.AddCrewCredit(db))
+        authGroup.PATCH("/api/movies/:id/crew/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateCrewCredit(db))
+        authGroup.DELETE("/api/movies/:id/crew/:creditId", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.RemoveCrewCredit(db))
+        authGroup.POST("/api/genres", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.GenreTable))
+        authGroup.PUT("/api/genres/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.GenreTable))
+        authGroup.DELETE("/api/genres/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.GenreTable))
+        authGroup.POST("/api/people", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.PersonTable))
+        authGroup.PUT("/api/people/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.PersonTable))
+        authGroup.DELETE("/api/people/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.PersonTable))
+        authGroup.POST("/api/countries", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.CountryTable))
+        authGroup.PUT("/api/countries/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.CountryTable))
+        authGroup.DELETE("/api/countries/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.CountryTable))
+        authGroup.POST("/api/languages", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.CreateReference(db, handlers.LanguageTable))
+        authGroup.PUT("/api/languages/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.UpdateReference(db, handlers.LanguageTable))
+        authGroup.DELETE("/api/languages/:id", auth.RequireScope(auth.ScopeMoviesWrite), auth.RequireRole(models.RoleCurator), auth.RequireVerifiedEmail(), handlers.DeleteReference(db, handlers.LanguageTable))
+        authGroup.GET("/api/users/me", handlers.GetMyProfile(db))
+    }
+
+    sessionGroup := authGroup.Group("/api")
+    sessionGroup.Use(auth.RequireSession())
+    {
+        sessionGroup.POST("/logout", auth.LogoutHandler(db))
+        sessionGroup.PATCH("/users/me", handlers.UpdateMyProfile(db, testMailer))
+        sessionGroup.DELETE("/users/me", auth.DeleteAccount(db, 30*24*time.Hour))
+        sessionGroup.GET("/users/me/export", handlers.ExportMyData(db))
+        sessionGroup.POST("/users/me/verify-email", auth.ResendVerification(db, testMailer))
+        sessionGroup.GET("/users/me/sessions", auth.ListSessions(db))
+        sessionGroup.DELETE("/users/me/sessions/:id", auth.RevokeSessionHandler(db))
+        sessionGroup.POST("/users/me/password", auth.ChangePassword(db))
+        sessionGroup.POST("/users/me/mfa/totp", auth.StartTOTPEnrollment(db))
+        sessionGroup.POST("/users/me/mfa/totp/verify", auth.ConfirmTOTPEnrollment(db))
+        sessionGroup.DELETE("/users/me/mfa/totp", auth.DisableTOTP(db))
+        sessionGroup.POST("/users/me/mfa/recovery-codes", auth.RegenerateRecoveryCodes(db))
+        sessionGroup.POST("/api-keys", auth.CreateAPIKey(db))
+        sessionGroup.GET("/api-keys", auth.ListAPIKeys(db))
+        sessionGroup.DELETE("/api-keys/:id", auth.RevokeAPIKey(db))
+    }
+
+    adminGroup := authGroup.Group("/api/admin")
+    adminGroup.Use(auth.RequireScope(auth.ScopeAdmin), auth.RequireRole(models.RoleAdmin))
+    {
+        adminGroup.PUT("/users/:id/role", auth.GrantRole(db))
+        adminGroup.DELETE("/users/:id/role", auth.RevokeRole(db))
+        adminGroup.POST("/users/:id/restore", auth.RestoreAccount(db))
+        adminGroup.POST("/service-accounts", auth.CreateServiceAccount(db))
+        adminGroup.GET("/lockouts", auth.ListLockouts(db))
+        adminGroup.POST("/unlock", auth.UnlockLogin(db))
+        adminGroup.GET("/audit", auth.ListAuthEvents(db))
+        adminGroup.DELETE("/movies/:id", handlers.PurgeMovie(db))
     }
 
     return r
 }
 
+func doRequest(router *gin.Engine, method, path, body, token string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest(method, path, strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    if token != "" {
+        req.Header.Set("Authorization", token)
+    }
+    resp := httptest.NewRecorder()
+    router.ServeHTTP(resp, req)
+    return resp
+}
+
+// signup creates a user and returns its id and access token.
+func signup(router *gin.Engine, username, password string) (uint, string) {
+    resp := doRequest(router, "POST", "/api/users",
+        `{"username": "`+username+`", "password": "`+password+`"}`, "")
+    var created struct {
+        ID    uint   `json:"id"`
+        Token string `json:"token"`
+    }
+    json.Unmarshal(resp.Body.Bytes(), &created)
+    return created.ID, created.Token
+}
+
 func TestPublicRoutes(t *testing.T) {
     router := setupRouter()
 
@@ -130,7 +262,22 @@ func TestAuthenticatedEndpoints(t *testing.T) {
 		token = tokenResponse.Token
     })
 
+    t.Run("POST /api/movies (without curator role)", func(t *testing.T) {
+        reqBody := `{"title": "Inception", "director": "Christopher Nolan", "year": 2010}`
+        req, _ := http.NewRequest("POST", "/api/movies", strings.NewReader(reqBody))
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("Authorization", token)
+        resp := httptest.NewRecorder()
+        router.ServeHTTP(resp, req)
+
+        if resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
     t.Run("POST /api/movies (authenticated)", func(t *testing.T) {
+        testDB.Model(&models.User{}).Where("username = ?", "testpassword").Updates(map[string]interface{}{"role": models.RoleCurator, "email_verified_at": time.Now()})
+
         reqBody := `{"title": "Inception", "director": "Christopher Nolan", "year": 2010}`
         req, _ := http.NewRequest("POST", "/api/movies", strings.NewReader(reqBody))
         req.Header.Set("Content-Type", "application/json")
@@ -183,4 +330,2373 @@ func TestLogin(t *testing.T) {
             t.Errorf("Expected status %d but got %d", http.StatusOK, resp.Code)
         }
     })
-}
\ No newline at end of file
+}
+func TestKeyRotation(t *testing.T) {
+    router := setupRouter()
+
+    oldKey := auth.NewHMACKey("old", []byte("old-secret"))
+    newKey := auth.NewHMACKey("new", []byte("new-secret"))
+
+    keys, _ := auth.NewKeyManager([]*auth.SigningKey{oldKey}, "old")
+    auth.SetKeyManager(keys)
+    defer auth.SetKeyManager(nil)
+
+    reqBody := `{"username": "rotationuser", "password": "testpassword"}`
+    req, _ := http.NewRequest("POST", "/api/users", strings.NewReader(reqBody))
+    req.Header.Set("Content-Type", "application/json")
+    resp := httptest.NewRecorder()
+    router.ServeHTTP(resp, req)
+
+    var created struct {
+        Token string `json:"token"`
+    }
+    json.Unmarshal(resp.Body.Bytes(), &created)
+
+    authStatus := func() int {
+        req, _ := http.NewRequest("POST", "/api/reviews", strings.NewReader(`{}`))
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("Authorization", created.Token)
+        resp := httptest.NewRecorder()
+        router.ServeHTTP(resp, req)
+        return resp.Code
+    }
+
+    t.Run("old key still accepted after rotation", func(t *testing.T) {
+        rotated, _ := auth.NewKeyManager([]*auth.SigningKey{oldKey, newKey}, "new")
+        auth.SetKeyManager(rotated)
+
+        if code := authStatus(); code == http.StatusUnauthorized {
+            t.Errorf("Expected token signed by retired key to be accepted, got %d", code)
+        }
+    })
+
+    t.Run("removed key rejected", func(t *testing.T) {
+        retired, _ := auth.NewKeyManager([]*auth.SigningKey{newKey}, "new")
+        auth.SetKeyManager(retired)
+
+        if code := authStatus(); code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, code)
+        }
+    })
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+    router := setupRouter()
+
+    post := func(path, body, token string) *httptest.ResponseRecorder {
+        return doRequest(router, "POST", path, body, token)
+    }
+
+    var tokens struct {
+        Token        string `json:"token"`
+        RefreshToken string `json:"refresh_token"`
+    }
+    post("/api/users", `{"username": "refreshuser", "password": "testpassword"}`, "")
+    resp := post("/api/token", `{"username": "refreshuser", "password": "testpassword"}`, "")
+    json.Unmarshal(resp.Body.Bytes(), &tokens)
+    firstRefresh := tokens.RefreshToken
+
+    t.Run("POST /api/token/refresh rotates the refresh token", func(t *testing.T) {
+        resp := post("/api/token/refresh", `{"refresh_token": "`+firstRefresh+`"}`, "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        json.Unmarshal(resp.Body.Bytes(), &tokens)
+        if tokens.RefreshToken == firstRefresh {
+            t.Errorf("Expected a new refresh token")
+        }
+    })
+
+    t.Run("reusing a rotated refresh token revokes the session", func(t *testing.T) {
+        resp := post("/api/token/refresh", `{"refresh_token": "`+firstRefresh+`"}`, "")
+        if resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+        resp = post("/api/token/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, "")
+        if resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+        resp = post("/api/reviews", `{}`, tokens.Token)
+        if resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected access tokens of the session to be revoked, got %d", resp.Code)
+        }
+    })
+
+    t.Run("POST /api/logout revokes the access token", func(t *testing.T) {
+        resp := post("/api/token", `{"username": "refreshuser", "password": "testpassword"}`, "")
+        json.Unmarshal(resp.Body.Bytes(), &tokens)
+
+        resp = post("/api/logout", "", tokens.Token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        resp = post("/api/reviews", `{}`, tokens.Token)
+        if resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+    })
+}
+
+func TestRoleAdministration(t *testing.T) {
+    router := setupRouter()
+
+    adminID, adminToken := signup(router, "roleadmin", "testpassword")
+    userID, userToken := signup(router, "rolecurator", "testpassword")
+    auth.EnsureAdmin(testDB, "roleadmin")
+    testDB.Model(&models.User{}).Where("id = ?", userID).Update("email_verified_at", time.Now())
+
+    t.Run("PUT /api/admin/users/:id/role (non-admin)", func(t *testing.T) {
+        resp := doRequest(router, "PUT", fmt.Sprintf("/api/admin/users/%d/role", userID), `{"role": "curator"}`, userToken)
+        if resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
+    t.Run("PUT /api/admin/users/:id/role", func(t *testing.T) {
+        resp := doRequest(router, "PUT", fmt.Sprintf("/api/admin/users/%d/role", userID), `{"role": "curator"}`, adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        resp = doRequest(router, "POST", "/api/movies", `{"title": "Heat", "year": 1995}`, userToken)
+        if resp.Code != http.StatusCreated {
+            t.Errorf("Expected status %d but got %d", http.StatusCreated, resp.Code)
+        }
+    })
+
+    t.Run("DELETE /api/admin/users/:id/role", func(t *testing.T) {
+        resp := doRequest(router, "DELETE", fmt.Sprintf("/api/admin/users/%d/role", userID), "", adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        resp = doRequest(router, "POST", "/api/movies", `{"title": "Ronin", "year": 1998}`, userToken)
+        if resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
+    t.Run("last admin cannot be demoted", func(t *testing.T) {
+        resp := doRequest(router, "DELETE", fmt.Sprintf("/api/admin/users/%d/role", adminID), "", adminToken)
+        if resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d but got %d", http.StatusConflict, resp.Code)
+        }
+    })
+}
+
+func TestAPIKeys(t *testing.T) {
+    router := setupRouter()
+
+    _, adminToken := signup(router, "keyadmin", "testpassword")
+    auth.EnsureAdmin(testDB, "keyadmin")
+
+    var account struct {
+        ID uint `json:"id"`
+    }
+    resp := doRequest(router, "POST", "/api/admin/service-accounts", `{"username": "ingestion-bot", "role": "curator"}`, adminToken)
+    if resp.Code != http.StatusCreated {
+        t.Fatalf("Failed to create service account. Expected status %d but got %d", http.StatusCreated, resp.Code)
+    }
+    json.Unmarshal(resp.Body.Bytes(), &account)
+
+    var key struct {
+        ID  uint   `json:"id"`
+        Key string `json:"key"`
+    }
+    resp = doRequest(router, "POST", "/api/api-keys",
+        fmt.Sprintf(`{"name": "ingestion", "scopes": ["movies:write"], "user_id": %d}`, account.ID), adminToken)
+    if resp.Code != http.StatusCreated {
+        t.Fatalf("Failed to create API key. Expected status %d but got %d", http.StatusCreated, resp.Code)
+    }
+    json.Unmarshal(resp.Body.Bytes(), &key)
+
+    withKey := func(method, path, body string) int {
+        req, _ := http.NewRequest(method, path, strings.NewReader(body))
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("X-API-Key", key.Key)
+        resp := httptest.NewRecorder()
+        router.ServeHTTP(resp, req)
+        return resp.Code
+    }
+
+    t.Run("POST /api/movies with scoped key", func(t *testing.T) {
+        if code := withKey("POST", "/api/movies", `{"title": "Alien", "year": 1979}`); code != http.StatusCreated {
+            t.Errorf("Expected status %d but got %d", http.StatusCreated, code)
+        }
+    })
+
+    t.Run("POST /api/reviews without scope", func(t *testing.T) {
+        if code := withKey("POST", "/api/reviews", `{"movie_id": 1, "text": "ok"}`); code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, code)
+        }
+    })
+
+    t.Run("POST /api/api-keys with a key", func(t *testing.T) {
+        if code := withKey("POST", "/api/api-keys", `{"name": "more", "scopes": ["admin"]}`); code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, code)
+        }
+    })
+
+    t.Run("POST /api/api-keys with a read scope", func(t *testing.T) {
+        for _, scope := range []string{"movies:read", "reviews:read"} {
+            resp := doRequest(router, "POST", "/api/api-keys", `{"name": "reader", "scopes": ["`+scope+`"]}`, adminToken)
+            if resp.Code != http.StatusBadRequest {
+                t.Errorf("%s: expected status %d but got %d", scope, http.StatusBadRequest, resp.Code)
+            }
+        }
+    })
+
+    t.Run("DELETE /api/api-keys/:id", func(t *testing.T) {
+        resp := doRequest(router, "DELETE", fmt.Sprintf("/api/api-keys/%d", key.ID), "", adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if code := withKey("POST", "/api/movies", `{"title": "Aliens", "year": 1986}`); code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, code)
+        }
+    })
+}
+
+func TestPasswordChangeAndReset(t *testing.T) {
+    router := setupRouter()
+
+    resp := doRequest(router, "POST", "/api/users",
+        `{"username": "resetuser", "password": "testpassword", "email": "reset@example.com"}`, "")
+    var created struct {
+        Token string `json:"token"`
+    }
+    json.Unmarshal(resp.Body.Bytes(), &created)
+
+    login := func(password string) int {
+        return doRequest(router, "POST", "/api/token", `{"username": "resetuser", "password": "`+password+`"}`, "").Code
+    }
+
+    t.Run("POST /api/users/me/password (wrong current password)", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users/me/password",
+            `{"current_password": "wrong", "new_password": "changedpassword"}`, created.Token)
+        if resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+    })
+
+    t.Run("POST /api/users/me/password", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users/me/password",
+            `{"current_password": "testpassword", "new_password": "changedpassword"}`, created.Token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if code := login("changedpassword"); code != http.StatusOK {
+            t.Errorf("Expected login with new password to succeed, got %d", code)
+        }
+    })
+
+    var resetToken string
+    t.Run("POST /api/password-reset", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/password-reset", `{"email": "reset@example.com"}`, "")
+        if resp.Code != http.StatusAccepted {
+            t.Fatalf("Expected status %d but got %d", http.StatusAccepted, resp.Code)
+        }
+        msg, ok := testMailer.Last("reset@example.com")
+        if !ok {
+            t.Fatalf("Expected a reset email")
+        }
+        for _, line := range strings.Split(msg.Body, "\n") {
+            if len(line) == 43 {
+                resetToken = line
+            }
+        }
+    })
+
+    t.Run("POST /api/password-reset/confirm", func(t *testing.T) {
+        body := `{"token": "` + resetToken + `", "new_password": "resetpassword"}`
+        resp := doRequest(router, "POST", "/api/password-reset/confirm", body, "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if code := login("resetpassword"); code != http.StatusOK {
+            t.Errorf("Expected login with reset password to succeed, got %d", code)
+        }
+
+        resp = doRequest(router, "POST", "/api/password-reset/confirm", body, "")
+        if resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected reused token to fail with %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+    })
+
+    t.Run("a failed reset email answers like an unknown address", func(t *testing.T) {
+        failing := gin.New()
+        failing.POST("/api/password-reset", auth.RequestPasswordReset(testDB, failingMailer{}))
+
+        unknown := doRequest(failing, "POST", "/api/password-reset", `{"email": "nobody@example.com"}`, "")
+        known := doRequest(failing, "POST", "/api/password-reset", `{"email": "reset@example.com"}`, "")
+        if known.Code != http.StatusAccepted || known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+            t.Errorf("Expected the same answer, got %d %s and %d %s", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+        }
+
+        var events int64
+        testDB.Model(&models.AuthEvent{}).Where("event = ? AND username = ?", models.EventResetMailFailed, "resetuser").Count(&events)
+        if events != 1 {
+            t.Errorf("Expected the failure to be audited, got %d events", events)
+        }
+    })
+}
+
+// failingMailer fails every delivery.
+type failingMailer struct{}
+
+func (failingMailer) Send(mail.Message) error {
+    return errors.New("mail server unavailable")
+}
+
+func TestLoginLockout(t *testing.T) {
+    router := setupRouter()
+
+    auth.SetLoginPolicy(config.LoginConfig{
+        UserFreeAttempts:     1,
+        UserLockoutThreshold: 3,
+        IPFreeAttempts:       100,
+        IPLockoutThreshold:   100,
+        LockoutDuration:      time.Minute,
+    })
+    defer auth.SetLoginPolicy(config.DefaultLoginConfig())
+
+    _, adminToken := signup(router, "lockadmin", "testpassword")
+    auth.EnsureAdmin(testDB, "lockadmin")
+    signup(router, "lockeduser", "testpassword")
+
+    login := func(password string) *httptest.ResponseRecorder {
+        return doRequest(router, "POST", "/api/token", `{"username": "lockeduser", "password": "`+password+`"}`, "")
+    }
+
+    t.Run("failed attempts lock the account", func(t *testing.T) {
+        for i := 0; i < 3; i++ {
+            // Clear the backoff delay so every attempt reaches the password check.
+            testDB.Model(&models.LoginThrottle{}).Where("throttle_key = ?", "user:lockeduser").Update("locked_until", nil)
+            login("wrongpassword")
+        }
+
+        resp := login("testpassword")
+        if resp.Code != http.StatusTooManyRequests {
+            t.Fatalf("Expected status %d but got %d", http.StatusTooManyRequests, resp.Code)
+        }
+        if resp.Header().Get("Retry-After") == "" {
+            t.Errorf("Expected a Retry-After header")
+        }
+    })
+
+    t.Run("GET /api/admin/lockouts", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/admin/lockouts?active=true", "", adminToken)
+        var events []models.LockoutEvent
+        json.Unmarshal(resp.Body.Bytes(), &events)
+        if resp.Code != http.StatusOK || len(events) != 1 || events[0].Username != "lockeduser" {
+            t.Errorf("Expected one active lockout, got %d %s", resp.Code, resp.Body.String())
+        }
+    })
+
+    t.Run("POST /api/admin/unlock", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/admin/unlock", `{"username": "lockeduser"}`, adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := login("testpassword"); resp.Code != http.StatusOK {
+            t.Errorf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+    })
+}
+
+// newMockIdP starts a minimal OpenID Connect provider. Codes must be
+// registered with the nonce and PKCE challenge of the login they belong to.
+// A code of the form "name/..." signs in as the subject sso-name with the
+// verified email name@example.com.
+func newMockIdP(t *testing.T) (*httptest.Server, map[string][2]string) {
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    idpKeys, _ := auth.NewKeyManager([]*auth.SigningKey{auth.NewRSAKey("idp-key", rsaKey)}, "idp-key")
+    codes := map[string][2]string{}
+
+    mux := http.NewServeMux()
+    server := httptest.NewServer(mux)
+    mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{
+            "issuer":                 server.URL,
+            "authorization_endpoint": server.URL + "/authorize",
+            "token_endpoint":         server.URL + "/token",
+            "jwks_uri":               server.URL + "/jwks",
+        })
+    })
+    mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(idpKeys.JWKS())
+    })
+    mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+        r.ParseForm()
+        pending, ok := codes[r.Form.Get("code")]
+        sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+        if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != pending[1] {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+            return
+        }
+        sub, email, username := "sso-subject-1", "sso@example.com", "ssouser"
+        if name, _, ok := strings.Cut(r.Form.Get("code"), "/"); ok {
+            sub, email, username = "sso-"+name, name+"@example.com", name
+        }
+        idToken, _ := idpKeys.Sign(jwt.MapClaims{
+            "iss":                server.URL,
+            "aud":                "movie-api",
+            "sub":                sub,
+            "email":              email,
+            "email_verified":     true,
+            "preferred_username": username,
+            "nonce":              pending[0],
+            "iat":                time.Now().Unix(),
+            "exp":                time.Now().Add(time.Minute).Unix(),
+        })
+        json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+    })
+
+    t.Cleanup(server.Close)
+    return server, codes
+}
+
+func TestOIDCLogin(t *testing.T) {
+    idp, codes := newMockIdP(t)
+    provider := auth.NewOIDCProvider(config.OIDCConfig{
+        Issuer:      idp.URL,
+        ClientID:    "movie-api",
+        RedirectURL: "http://localhost:8000/api/oidc/callback",
+        Scopes:      []string{"openid", "email"},
+    })
+
+    router := setupRouter()
+    router.GET("/api/oidc/login", auth.OIDCLogin(testDB, provider))
+    router.GET("/api/oidc/callback", auth.OIDCCallback(testDB, provider))
+
+    login := func(code string) (*httptest.ResponseRecorder, string) {
+        resp := doRequest(router, "GET", "/api/oidc/login", "", "")
+        if resp.Code != http.StatusFound {
+            t.Fatalf("Expected status %d but got %d", http.StatusFound, resp.Code)
+        }
+        location, _ := url.Parse(resp.Header().Get("Location"))
+        query := location.Query()
+        if query.Get("code_challenge_method") != "S256" {
+            t.Errorf("Expected a PKCE S256 challenge")
+        }
+        codes[code] = [2]string{query.Get("nonce"), query.Get("code_challenge")}
+
+        state := query.Get("state")
+        return doRequest(router, "GET", "/api/oidc/callback?code="+code+"&state="+state, "", ""), state
+    }
+
+    t.Run("GET /api/oidc/callback creates the user", func(t *testing.T) {
+        resp, state := login("code-1")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+
+        var tokens struct {
+            Token string `json:"token"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &tokens)
+        if tokens.Token == "" {
+            t.Errorf("Expected an API token")
+        }
+
+        replay := doRequest(router, "GET", "/api/oidc/callback?code=code-1&state="+state, "", "")
+        if replay.Code != http.StatusBadRequest {
+            t.Errorf("Expected replayed state to fail with %d but got %d", http.StatusBadRequest, replay.Code)
+        }
+    })
+
+    t.Run("second login reuses the linked user", func(t *testing.T) {
+        if resp, _ := login("code-2"); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+
+        var count int64
+        testDB.Model(&models.User{}).Where("oidc_subject = ?", "sso-subject-1").Count(&count)
+        if count != 1 {
+            t.Errorf("Expected exactly one linked user, got %d", count)
+        }
+    })
+
+    t.Run("users with TOTP get an MFA challenge", func(t *testing.T) {
+        testDB.Model(&models.User{}).Where("oidc_subject = ?", "sso-subject-1").Update("TOTPEnabled", true)
+        defer testDB.Model(&models.User{}).Where("oidc_subject = ?", "sso-subject-1").Update("TOTPEnabled", false)
+
+        resp, _ := login("code-3")
+        var challenge struct {
+            Token       string `json:"token"`
+            MFARequired bool   `json:"mfa_required"`
+            MFAToken    string `json:"mfa_token"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &challenge)
+        if resp.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" || challenge.Token != "" {
+            t.Errorf("Expected an MFA challenge instead of tokens, got %d %s", resp.Code, resp.Body.String())
+        }
+    })
+
+    t.Run("an unverified local email is not linked", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users",
+            `{"username": "ssosquatter", "password": "testpassword", "email": "ssovictim@example.com"}`, "")
+        if resp.Code != http.StatusCreated {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+        }
+
+        if resp, _ := login("ssovictim/1"); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var local models.User
+        testDB.Where("username = ?", "ssosquatter").First(&local)
+        if local.OIDCSubject != nil || local.EmailVerifiedAt != nil {
+            t.Errorf("Expected the unverified local account to stay unlinked and unverified")
+        }
+        var linked models.User
+        if err := testDB.Where("oidc_subject = ?", "sso-ssovictim").First(&linked).Error; err != nil || linked.ID == local.ID {
+            t.Errorf("Expected the SSO login to get its own account")
+        }
+    })
+
+    t.Run("a verified local email is linked", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users",
+            `{"username": "ssoowner", "password": "testpassword", "email": "ssoowner@example.com"}`, "")
+        if resp.Code != http.StatusCreated {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+        }
+        verifiedAt := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+        testDB.Model(&models.User{}).Where("username = ?", "ssoowner").Update("email_verified_at", verifiedAt)
+
+        if resp, _ := login("ssoowner/1"); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var local models.User
+        testDB.Where("username = ?", "ssoowner").First(&local)
+        if local.OIDCSubject == nil || *local.OIDCSubject != "sso-ssoowner" {
+            t.Errorf("Expected the verified local account to be linked")
+        }
+        if local.EmailVerifiedAt == nil || !local.EmailVerifiedAt.Equal(verifiedAt) {
+            t.Errorf("Expected linking to keep the local verification time, got %v", local.EmailVerifiedAt)
+        }
+    })
+}
+
+// totpNow computes the current RFC 6238 code for a base32 secret.
+func totpNow(secret string) string {
+    key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+    var counter [8]byte
+    binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(counter[:])
+    sum := mac.Sum(nil)
+    offset := sum[len(sum)-1] & 0x0f
+    return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTPLogin(t *testing.T) {
+    router := setupRouter()
+    _, token := signup(router, "mfauser", "testpassword")
+
+    var enrollment struct {
+        Secret     string `json:"secret"`
+        OTPAuthURI string `json:"otpauth_uri"`
+    }
+    var recovery struct {
+        RecoveryCodes []string `json:"recovery_codes"`
+    }
+
+    t.Run("POST /api/users/me/mfa/totp", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users/me/mfa/totp", "", token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        json.Unmarshal(resp.Body.Bytes(), &enrollment)
+        if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") {
+            t.Errorf("Unexpected otpauth URI %q", enrollment.OTPAuthURI)
+        }
+    })
+
+    t.Run("POST /api/users/me/mfa/totp/verify", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users/me/mfa/totp/verify", `{"code": "`+totpNow(enrollment.Secret)+`"}`, token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        json.Unmarshal(resp.Body.Bytes(), &recovery)
+        if len(recovery.RecoveryCodes) != 10 {
+            t.Errorf("Expected 10 recovery codes, got %d", len(recovery.RecoveryCodes))
+        }
+    })
+
+    var challenge struct {
+        MFARequired bool   `json:"mfa_required"`
+        MFAToken    string `json:"mfa_token"`
+    }
+    t.Run("POST /api/token returns an MFA challenge", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/token", `{"username": "mfauser", "password": "testpassword"}`, "")
+        json.Unmarshal(resp.Body.Bytes(), &challenge)
+        if resp.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+            t.Fatalf("Expected an MFA challenge, got %d %s", resp.Code, resp.Body.String())
+        }
+        if resp := doRequest(router, "POST", "/api/reviews", `{}`, challenge.MFAToken); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected MFA token to be rejected as access token, got %d", resp.Code)
+        }
+    })
+
+    t.Run("POST /api/token/mfa with a recovery code", func(t *testing.T) {
+        body := `{"mfa_token": "` + challenge.MFAToken + `", "code": "` + recovery.RecoveryCodes[0] + `"}`
+        resp := doRequest(router, "POST", "/api/token/mfa", body, "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+
+        if resp := doRequest(router, "POST", "/api/token/mfa", body, ""); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected reused challenge to fail with %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+    })
+}
+
+func TestUserProfile(t *testing.T) {
+    router := setupRouter()
+    userID, token := signup(router, "profileuser", "testpassword")
+    signup(router, "profileother", "testpassword")
+    testDB.Model(&models.User{}).Where("username = ?", "profileother").Update("email", "taken@example.com")
+
+    t.Run("GET /api/users/me", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/users/me", "", token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "GET", "/api/users/me", "", ""); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d without token but got %d", http.StatusUnauthorized, resp.Code)
+        }
+    })
+
+    t.Run("PATCH /api/users/me", func(t *testing.T) {
+        body := `{"display_name": "Profile User", "bio": "Likes noir.", "avatar_url": "https://example.com/a.png"}`
+        resp := doRequest(router, "PATCH", "/api/users/me", body, token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var profile struct {
+            DisplayName string `json:"display_name"`
+            Bio         string `json:"bio"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &profile)
+        if profile.DisplayName != "Profile User" || profile.Bio != "Likes noir." {
+            t.Errorf("Profile was not updated: %s", resp.Body.String())
+        }
+
+        if resp := doRequest(router, "PATCH", "/api/users/me", `{"avatar_url": "javascript:alert(1)"}`, token); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d for invalid avatar but got %d", http.StatusBadRequest, resp.Code)
+        }
+        if resp := doRequest(router, "PATCH", "/api/users/me", `{"email": "taken@example.com"}`, token); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d for taken email but got %d", http.StatusConflict, resp.Code)
+        }
+    })
+
+    t.Run("GET /api/users/:username", func(t *testing.T) {
+        testDB.Omit("Movie", "User").Create(&models.Review{MovieID: 9001, UserID: userID, Rating: 4})
+        testDB.Omit("Movie", "User").Create(&models.Review{MovieID: 9002, UserID: userID, Rating: 2})
+
+        resp := doRequest(router, "GET", "/api/users/profileuser", "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var public struct {
+            DisplayName string `json:"display_name"`
+            Stats       struct {
+                ReviewCount        int64   `json:"review_count"`
+                AverageRatingGiven float64 `json:"average_rating_given"`
+            } `json:"stats"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &public)
+        if public.DisplayName != "Profile User" || public.Stats.ReviewCount != 2 || public.Stats.AverageRatingGiven != 3 {
+            t.Errorf("Unexpected public profile: %s", resp.Body.String())
+        }
+        if strings.Contains(resp.Body.String(), "email") {
+            t.Errorf("Public profile must not expose the email address")
+        }
+
+        if resp := doRequest(router, "GET", "/api/users/nosuchuser", "", ""); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+}
+
+func TestAccountDeletion(t *testing.T) {
+    router := setupRouter()
+
+    _, adminToken := signup(router, "gdpradmin", "testpassword")
+    auth.EnsureAdmin(testDB, "gdpradmin")
+    userID, token := signup(router, "gdpruser", "testpassword")
+    otherID, otherToken := signup(router, "gdprother", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: 9101, UserID: userID, Rating: 4, Text: "Kept"})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: 9101, UserID: otherID, Rating: 1, Text: "Removed"})
+
+    t.Run("GET /api/users/me/export", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/users/me/export", "", token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if !strings.Contains(resp.Header().Get("Content-Disposition"), "attachment") {
+            t.Errorf("Expected the export to be served as an attachment")
+        }
+        var export struct {
+            Profile struct {
+                Username string `json:"username"`
+            } `json:"profile"`
+            Reviews []struct {
+                Text string `json:"text"`
+            } `json:"reviews"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &export)
+        if export.Profile.Username != "gdpruser" || len(export.Reviews) != 1 || export.Reviews[0].Text != "Kept" {
+            t.Errorf("Unexpected export: %s", resp.Body.String())
+        }
+    })
+
+    t.Run("DELETE /api/users/me", func(t *testing.T) {
+        if resp := doRequest(router, "DELETE", "/api/users/me", `{"reviews": "delete", "password": "wrong"}`, otherToken); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+        if resp := doRequest(router, "DELETE", "/api/users/me", `{"reviews": "delete", "password": "testpassword"}`, otherToken); resp.Code != http.StatusAccepted {
+            t.Fatalf("Expected status %d but got %d", http.StatusAccepted, resp.Code)
+        }
+
+        var reviews int64
+        testDB.Model(&models.Review{}).Where("user_id = ?", otherID).Count(&reviews)
+        if reviews != 0 {
+            t.Errorf("Expected reviews to be hidden, %d visible", reviews)
+        }
+        if resp := doRequest(router, "GET", "/api/users/me", "", otherToken); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected deleted account to be signed out, got %d", resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/token", `{"username": "gdprother", "password": "testpassword"}`, ""); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected login to fail, got %d", resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/users", `{"username": "gdprother", "password": "testpassword"}`, ""); resp.Code != http.StatusConflict {
+            t.Errorf("Expected username to stay reserved during the grace period, got %d", resp.Code)
+        }
+    })
+
+    t.Run("POST /api/admin/users/:id/restore", func(t *testing.T) {
+        resp := doRequest(router, "POST", fmt.Sprintf("/api/admin/users/%d/restore", otherID), "", adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var reviews int64
+        testDB.Model(&models.Review{}).Where("user_id = ?", otherID).Count(&reviews)
+        if reviews != 1 {
+            t.Errorf("Expected the review to be restored, %d visible", reviews)
+        }
+        if resp := doRequest(router, "POST", "/api/token", `{"username": "gdprother", "password": "testpassword"}`, ""); resp.Code != http.StatusOK {
+            t.Errorf("Expected login to work again, got %d", resp.Code)
+        }
+    })
+
+    t.Run("purge after the grace period", func(t *testing.T) {
+        bystanderID, _ := signup(router, "gdprbystander", "testpassword")
+        moderated := models.Review{MovieID: 999, UserID: bystanderID, Rating: 2, Text: "Removed by a moderator"}
+        testDB.Omit("Movie", "User").Create(&moderated)
+        testDB.Delete(&moderated)
+
+        resp := doRequest(router, "DELETE", "/api/users/me", `{"reviews": "anonymize", "password": "testpassword"}`, token)
+        if resp.Code != http.StatusAccepted {
+            t.Fatalf("Expected status %d but got %d", http.StatusAccepted, resp.Code)
+        }
+        var login struct {
+            Token string `json:"token"`
+        }
+        resp = doRequest(router, "POST", "/api/token", `{"username": "gdprother", "password": "testpassword"}`, "")
+        json.Unmarshal(resp.Body.Bytes(), &login)
+        resp = doRequest(router, "DELETE", "/api/users/me", `{"reviews": "delete", "password": "testpassword"}`, login.Token)
+        if resp.Code != http.StatusAccepted {
+            t.Fatalf("Expected status %d but got %d", http.StatusAccepted, resp.Code)
+        }
+
+        if err := auth.PurgeDeletedAccounts(testDB, time.Hour); err != nil {
+            t.Fatal(err)
+        }
+        var count int64
+        testDB.Unscoped().Model(&models.User{}).Where("username = ?", "gdpruser").Count(&count)
+        if count != 1 {
+            t.Errorf("Expected account to survive until the grace period ends")
+        }
+
+        if err := auth.PurgeDeletedAccounts(testDB, 0); err != nil {
+            t.Fatal(err)
+        }
+        var anonymized models.User
+        testDB.Unscoped().First(&anonymized, userID)
+        if anonymized.Username != fmt.Sprintf("deleted-%d", userID) || anonymized.Email != nil || anonymized.Password != "" {
+            t.Errorf("Expected anonymized account, got %q", anonymized.Username)
+        }
+        testDB.Model(&models.Review{}).Where("user_id = ?", userID).Count(&count)
+        if count != 1 {
+            t.Errorf("Expected anonymized review to be kept")
+        }
+        testDB.Unscoped().Model(&models.User{}).Where("id = ?", otherID).Count(&count)
+        if count != 0 {
+            t.Errorf("Expected deleted account to be purged")
+        }
+        testDB.Unscoped().Model(&models.Review{}).Where("user_id = ?", otherID).Count(&count)
+        if count != 0 {
+            t.Errorf("Expected deleted reviews to be purged")
+        }
+        testDB.Unscoped().Model(&models.Review{}).Where("id = ?", moderated.ID).Count(&count)
+        if count != 1 {
+            t.Errorf("Expected the moderated review of an active account to be kept")
+        }
+    })
+}
+
+func TestPasswordRehash(t *testing.T) {
+    router := setupRouter()
+    login := `{"username": "rehashuser", "password": "testpassword"}`
+    storedHash := func() string {
+        var user models.User
+        testDB.Where("username = ?", "rehashuser").First(&user)
+        return user.Password
+    }
+
+    legacy, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.MinCost)
+    testDB.Create(&models.User{Username: "rehashuser", Password: string(legacy)})
+
+    t.Run("bcrypt hash is upgraded to argon2id", func(t *testing.T) {
+        if resp := doRequest(router, "POST", "/api/token", login, ""); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if hash := storedHash(); !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+            t.Errorf("Expected an argon2id hash, got %q", hash)
+        }
+    })
+
+    t.Run("weak argon2id parameters are upgraded", func(t *testing.T) {
+        weak, _ := auth.Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("testpassword")
+        testDB.Model(&models.User{}).Where("username = ?", "rehashuser").Update("password", weak)
+
+        if resp := doRequest(router, "POST", "/api/token", login, ""); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        upgraded := storedHash()
+        if upgraded == weak || !strings.Contains(upgraded, "m=19456") {
+            t.Errorf("Expected the hash to be upgraded, got %q", upgraded)
+        }
+
+        doRequest(router, "POST", "/api/token", login, "")
+        if storedHash() != upgraded {
+            t.Errorf("Expected a current hash to be left alone")
+        }
+    })
+
+    t.Run("wrong password does not rehash", func(t *testing.T) {
+        before := storedHash()
+        if resp := doRequest(router, "POST", "/api/token", `{"username": "rehashuser", "password": "wrong"}`, ""); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected status %d but got %d", http.StatusUnauthorized, resp.Code)
+        }
+        if storedHash() != before {
+            t.Errorf("Expected the hash to be unchanged")
+        }
+    })
+}
+
+func TestSessionsAndAudit(t *testing.T) {
+    router := setupRouter()
+
+    _, adminToken := signup(router, "auditadmin", "testpassword")
+    auth.EnsureAdmin(testDB, "auditadmin")
+    _, signupToken := signup(router, "sessionuser", "testpassword")
+
+    req, _ := http.NewRequest("POST", "/api/token", strings.NewReader(`{"username": "sessionuser", "password": "testpassword"}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "audit-test-agent")
+    resp := httptest.NewRecorder()
+    router.ServeHTTP(resp, req)
+    var login struct {
+        Token string `json:"token"`
+    }
+    json.Unmarshal(resp.Body.Bytes(), &login)
+    doRequest(router, "POST", "/api/token", `{"username": "sessionuser", "password": "wrong"}`, "")
+
+    type session struct {
+        ID        uint   `json:"id"`
+        UserAgent string `json:"user_agent"`
+        Current   bool   `json:"current"`
+    }
+    listSessions := func() []session {
+        var sessions []session
+        resp := doRequest(router, "GET", "/api/users/me/sessions", "", login.Token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        json.Unmarshal(resp.Body.Bytes(), &sessions)
+        return sessions
+    }
+
+    t.Run("GET /api/users/me/sessions", func(t *testing.T) {
+        sessions := listSessions()
+        if len(sessions) != 2 {
+            t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+        }
+        for _, s := range sessions {
+            if s.Current && s.UserAgent != "audit-test-agent" {
+                t.Errorf("Expected the current session to record the user agent, got %q", s.UserAgent)
+            }
+        }
+    })
+
+    t.Run("DELETE /api/users/me/sessions/:id", func(t *testing.T) {
+        var other uint
+        for _, s := range listSessions() {
+            if !s.Current {
+                other = s.ID
+            }
+        }
+        resp := doRequest(router, "DELETE", fmt.Sprintf("/api/users/me/sessions/%d", other), "", login.Token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "GET", "/api/users/me", "", signupToken); resp.Code != http.StatusUnauthorized {
+            t.Errorf("Expected the revoked session's token to be rejected, got %d", resp.Code)
+        }
+        if sessions := listSessions(); len(sessions) != 1 || !sessions[0].Current {
+            t.Errorf("Expected only the current session to remain, got %+v", sessions)
+        }
+        if resp := doRequest(router, "DELETE", fmt.Sprintf("/api/users/me/sessions/%d", other), "", adminToken); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for another user's session but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("GET /api/admin/audit", func(t *testing.T) {
+        if resp := doRequest(router, "GET", "/api/admin/audit", "", login.Token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+
+        var events []struct {
+            Event     string `json:"event"`
+            UserAgent string `json:"user_agent"`
+        }
+        resp := doRequest(router, "GET", "/api/admin/audit?username=sessionuser", "", adminToken)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        json.Unmarshal(resp.Body.Bytes(), &events)
+        seen := map[string]int{}
+        for _, e := range events {
+            seen[e.Event]++
+        }
+        for _, event := range []string{"login_success", "login_failure", "token_issued", "session_revoked"} {
+            if seen[event] == 0 {
+                t.Errorf("Expected a %s event, got %v", event, seen)
+            }
+        }
+
+        resp = doRequest(router, "GET", "/api/admin/audit?username=sessionuser&event=login_success", "", adminToken)
+        json.Unmarshal(resp.Body.Bytes(), &events)
+        if len(events) != 1 || events[0].UserAgent != "audit-test-agent" {
+            t.Errorf("Unexpected login events: %s", resp.Body.String())
+        }
+
+        if resp := doRequest(router, "GET", "/api/admin/audit?since=yesterday", "", adminToken); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+    })
+}
+
+func TestPasswordPolicy(t *testing.T) {
+    router := setupRouter()
+    defer auth.SetPasswordPolicy(auth.NewPasswordPolicy(config.DefaultPasswordConfig()))
+
+    createUser := func(username, password string) *httptest.ResponseRecorder {
+        return doRequest(router, "POST", "/api/users", `{"username": "`+username+`", "password": "`+password+`"}`, "")
+    }
+
+    t.Run("POST /api/users rejects weak passwords", func(t *testing.T) {
+        for _, password := range []string{"short", "password", "iloveyou", "policyuser-2024"} {
+            resp := createUser("policyuser", password)
+            if resp.Code != http.StatusBadRequest {
+                t.Errorf("Expected %q to be rejected with %d but got %d", password, http.StatusBadRequest, resp.Code)
+            }
+        }
+        if resp := createUser("policyuser", "testpassword"); resp.Code != http.StatusCreated {
+            t.Fatalf("Expected status %d but got %d", http.StatusCreated, resp.Code)
+        }
+    })
+
+    t.Run("character classes are configurable", func(t *testing.T) {
+        cfg := config.DefaultPasswordConfig()
+        cfg.MinCharClasses = 3
+        auth.SetPasswordPolicy(auth.NewPasswordPolicy(cfg))
+
+        if resp := createUser("classuser", "onlylowercase"); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+        if resp := createUser("classuser", "Mixed-case-1"); resp.Code != http.StatusCreated {
+            t.Errorf("Expected status %d but got %d", http.StatusCreated, resp.Code)
+        }
+    })
+
+    t.Run("breached password list file", func(t *testing.T) {
+        path := t.TempDir() + "/breached.bin"
+        f, _ := os.Create(path)
+        breach.Write(f, []uint64{breach.Key("correct horse battery staple")})
+        f.Close()
+        list, err := breach.Open(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer list.Close()
+        auth.SetPasswordPolicy(auth.NewPasswordPolicy(config.DefaultPasswordConfig(), list))
+
+        if resp := createUser("breachuser", "correct horse battery staple"); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+
+        _, token := signup(router, "breachuser", "testpassword")
+        body := `{"current_password": "testpassword", "new_password": "correct horse battery staple"}`
+        if resp := doRequest(router, "POST", "/api/users/me/password", body, token); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected password change to be rejected with %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+    })
+}
+
+func TestEmailVerification(t *testing.T) {
+    router := setupRouter()
+
+    mailedToken := func(to string) string {
+        msg, ok := testMailer.Last(to)
+        if !ok {
+            t.Fatalf("Expected an email to %s", to)
+        }
+        for _, line := range strings.Split(msg.Body, "\n") {
+            if len(line) == 43 {
+                return line
+            }
+        }
+        t.Fatalf("No token in email to %s", to)
+        return ""
+    }
+
+    resp := doRequest(router, "POST", "/api/users", `{"username": "verifyuser", "password": "testpassword", "email": "verify@example.com"}`, "")
+    var created struct {
+        Token         string `json:"token"`
+        EmailVerified bool   `json:"email_verified"`
+    }
+    json.Unmarshal(resp.Body.Bytes(), &created)
+    if resp.Code != http.StatusCreated || created.EmailVerified {
+        t.Fatalf("Expected an unverified account, got %d %s", resp.Code, resp.Body.String())
+    }
+    token := mailedToken("verify@example.com")
+
+    t.Run("unverified accounts can read but not post", func(t *testing.T) {
+        if resp := doRequest(router, "GET", "/api/movies", "", created.Token); resp.Code != http.StatusOK {
+            t.Errorf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/reviews", `{"movie_id": 1, "user_rating": 4}`, created.Token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
+    t.Run("POST /api/users/me/verify-email", func(t *testing.T) {
+        if resp := doRequest(router, "POST", "/api/users/me/verify-email", "", created.Token); resp.Code != http.StatusTooManyRequests {
+            t.Errorf("Expected an immediate resend to get %d but got %d", http.StatusTooManyRequests, resp.Code)
+        }
+        testDB.Model(&models.EmailVerificationToken{}).Where("email = ?", "verify@example.com").
+            Update("created_at", time.Now().Add(-time.Hour))
+        if resp := doRequest(router, "POST", "/api/users/me/verify-email", "", created.Token); resp.Code != http.StatusAccepted {
+            t.Fatalf("Expected status %d but got %d", http.StatusAccepted, resp.Code)
+        }
+        token = mailedToken("verify@example.com")
+    })
+
+    t.Run("POST /api/users/verify-email", func(t *testing.T) {
+        if resp := doRequest(router, "POST", "/api/users/verify-email", `{"token": "invalid"}`, ""); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/users/verify-email", `{"token": "`+token+`"}`, ""); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/users/verify-email", `{"token": "`+token+`"}`, ""); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected a used token to get %d but got %d", http.StatusBadRequest, resp.Code)
+        }
+        if resp := doRequest(router, "POST", "/api/reviews", `{}`, created.Token); resp.Code == http.StatusForbidden {
+            t.Errorf("Expected a verified account to pass the verification check")
+        }
+        if resp := doRequest(router, "POST", "/api/users/me/verify-email", "", created.Token); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d but got %d", http.StatusConflict, resp.Code)
+        }
+    })
+
+    t.Run("changing the email requires verifying it again", func(t *testing.T) {
+        resp := doRequest(router, "PATCH", "/api/users/me", `{"email": "verify2@example.com"}`, created.Token)
+        var profile struct {
+            EmailVerified bool `json:"email_verified"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &profile)
+        if resp.Code != http.StatusOK || profile.EmailVerified {
+            t.Fatalf("Expected an unverified address, got %d %s", resp.Code, resp.Body.String())
+        }
+        mailedToken("verify2@example.com")
+        if resp := doRequest(router, "POST", "/api/reviews", `{}`, created.Token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+}
+
+func TestPagination(t *testing.T) {
+    router := setupRouter()
+    created := map[uint]bool{}
+    for i := 0; i < 5; i++ {
+        movie := models.Movie{Title: fmt.Sprintf("Paged Movie %d", i), Year: 2000 + i}
+        testDB.Omit("Country").Create(&movie)
+        created[movie.ID] = true
+    }
+
+    type moviePage struct {
+        Data []struct {
+            ID uint `json:"id"`
+        } `json:"data"`
+        Pagination struct {
+            Limit      int    `json:"limit"`
+            Total      int64  `json:"total"`
+            NextCursor string `json:"next_cursor"`
+        } `json:"pagination"`
+    }
+
+    t.Run("cursor walks every movie once", func(t *testing.T) {
+        seen := map[uint]bool{}
+        var total int64
+        path := "/api/movies?limit=2"
+        for pages := 0; path != ""; pages++ {
+            if pages > 1000 {
+                t.Fatal("pagination did not terminate")
+            }
+            resp := doRequest(router, "GET", path, "", "")
+            if resp.Code != http.StatusOK {
+                t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+            }
+            var page moviePage
+            json.Unmarshal(resp.Body.Bytes(), &page)
+            if len(page.Data) > 2 {
+                t.Fatalf("Expected at most 2 movies but got %d", len(page.Data))
+            }
+            if resp.Header().Get("X-Total-Count") != fmt.Sprint(page.Pagination.Total) {
+                t.Errorf("X-Total-Count %q does not match total %d", resp.Header().Get("X-Total-Count"), page.Pagination.Total)
+            }
+            for _, movie := range page.Data {
+                if seen[movie.ID] {
+                    t.Fatalf("movie %d returned twice", movie.ID)
+                }
+                seen[movie.ID] = true
+            }
+            total = page.Pagination.Total
+            path = ""
+            if page.Pagination.NextCursor != "" {
+                if !strings.Contains(resp.Header().Get("Link"), `rel="next"`) {
+                    t.Errorf("Expected a next link but got %q", resp.Header().Get("Link"))
+                }
+                path = "/api/movies?limit=2&cursor=" + page.Pagination.NextCursor
+            }
+        }
+        if int64(len(seen)) != total {
+            t.Errorf("Expected %d movies but saw %d", total, len(seen))
+        }
+        for id := range created {
+            if !seen[id] {
+                t.Errorf("movie %d was not listed", id)
+            }
+        }
+    })
+
+    t.Run("offset", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/movies?limit=2&offset=2", "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        link := resp.Header().Get("Link")
+        for _, rel := range []string{`rel="first"`, `rel="prev"`, `rel="last"`} {
+            if !strings.Contains(link, rel) {
+                t.Errorf("Expected %s in Link header %q", rel, link)
+            }
+        }
+        if !strings.Contains(link, "offset=0") {
+            t.Errorf("Expected prev link to offset 0 in %q", link)
+        }
+    })
+
+    t.Run("invalid parameters", func(t *testing.T) {
+        for _, path := range []string{
+            "/api/movies?limit=0",
+            "/api/movies?limit=101",
+            "/api/movies?offset=-1",
+            "/api/movies?cursor=not-a-cursor",
+            "/api/movies?offset=2&cursor=abc",
+        } {
+            if resp := doRequest(router, "GET", path, "", ""); resp.Code != http.StatusBadRequest {
+                t.Errorf("%s: expected status %d but got %d", path, http.StatusBadRequest, resp.Code)
+            }
+        }
+    })
+
+    t.Run("GET /api/reviews", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/reviews?limit=1", "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var page moviePage
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        if page.Pagination.Limit != 1 || page.Data == nil {
+            t.Errorf("Unexpected reviews page %s", resp.Body.String())
+        }
+    })
+}
+
+func TestMovieFilters(t *testing.T) {
+    router := setupRouter()
+    noir := models.Genre{Name: "Filter Noir"}
+    comedy := models.Genre{Name: "Filter Comedy"}
+    testDB.Create(&noir)
+    testDB.Create(&comedy)
+    country := models.Country{Name: "Filterland"}
+    testDB.Create(&country)
+    language := models.Language{Name: "Filterish"}
+    testDB.Create(&language)
+    director := models.Person{Name: "Filter Director"}
+    actor := models.Person{Name: "Filter Actor"}
+    testDB.Create(&director)
+    testDB.Create(&actor)
+
+    runtime := 95
+    first := models.Movie{Title: "Filter One", Year: 1950, Runtime: &runtime, CountryID: country.ID}
+    second := models.Movie{Title: "Filter Two", Year: 1975, CountryID: country.ID}
+    third := models.Movie{Title: "Filter Three", Year: 1990}
+    for _, movie := range []*models.Movie{&first, &second, &third} {
+        testDB.Omit("Country").Create(movie)
+    }
+    testDB.Create(&models.MovieGenre{MovieID: first.ID, GenreID: noir.ID})
+    testDB.Create(&models.MovieGenre{MovieID: second.ID, GenreID: comedy.ID})
+    testDB.Create(&models.MovieGenre{MovieID: third.ID, GenreID: noir.ID})
+    testDB.Create(&models.MovieDirector{MovieID: first.ID, PersonID: director.ID})
+    testDB.Create(&models.MovieActor{MovieID: second.ID, PersonID: actor.ID})
+    testDB.Exec("INSERT INTO movie_languages (movie_id, language_id) VALUES (?, ?)", third.ID, language.ID)
+
+    reviewer, _ := signup(router, "filterreviewer", "testpassword")
+    other, _ := signup(router, "filterreviewer2", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: first.ID, UserID: reviewer, Rating: 9})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: first.ID, UserID: other, Rating: 7})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: second.ID, UserID: reviewer, Rating: 3})
+
+    list := func(t *testing.T, query string) []string {
+        resp := doRequest(router, "GET", "/api/movies?limit=100&"+query, "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("%s: expected status %d but got %d: %s", query, http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var page struct {
+            Data []struct {
+                Title string `json:"title"`
+            } `json:"data"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        var titles []string
+        for _, movie := range page.Data {
+            if strings.HasPrefix(movie.Title, "Filter ") {
+                titles = append(titles, movie.Title)
+            }
+        }
+        return titles
+    }
+
+    cases := []struct {
+        query string
+        want  []string
+    }{
+        {"genre=filter%20noir", []string{"Filter One", "Filter Three"}},
+        {fmt.Sprintf("genre=%d,%d", noir.ID, comedy.ID), []string{"Filter One", "Filter Two", "Filter Three"}},
+        {"genre=Filter%20Noir&year_max=1960", []string{"Filter One"}},
+        {"year_min=1960&year_max=1980", []string{"Filter Two"}},
+        {fmt.Sprintf("country=%d", country.ID), []string{"Filter One", "Filter Two"}},
+        {"country=filterland&genre=Filter%20Comedy", []string{"Filter Two"}},
+        {"language=Filterish", []string{"Filter Three"}},
+        {fmt.Sprintf("director=%d", director.ID), []string{"Filter One"}},
+        {fmt.Sprintf("actor=%d,%d", actor.ID, director.ID), []string{"Filter Two"}},
+        {"runtime_min=90&runtime_max=100", []string{"Filter One"}},
+        {"min_rating=5&genre=Filter%20Noir,Filter%20Comedy", []string{"Filter One"}},
+        {"min_reviews=1&genre=Filter%20Noir,Filter%20Comedy", []string{"Filter One", "Filter Two"}},
+        {"min_reviews=2&min_rating=8", []string{"Filter One"}},
+    }
+    for _, tc := range cases {
+        if got := list(t, tc.query); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+            t.Errorf("%s: expected %v but got %v", tc.query, tc.want, got)
+        }
+    }
+
+    // Soft-deleted genres, languages and countries no longer match.
+    testDB.Delete(&noir)
+    testDB.Delete(&language)
+    testDB.Delete(&country)
+    for _, query := range []string{"genre=Filter%20Noir", fmt.Sprintf("genre=%d", noir.ID), "language=Filterish", "country=filterland"} {
+        if got := list(t, query); len(got) != 0 {
+            t.Errorf("%s: expected no movies for a deleted reference but got %v", query, got)
+        }
+    }
+
+    for _, query := range []string{"year_min=abc", "director=x", "min_rating=high"} {
+        if resp := doRequest(router, "GET", "/api/movies?"+query, "", ""); resp.Code != http.StatusBadRequest {
+            t.Errorf("%s: expected status %d but got %d", query, http.StatusBadRequest, resp.Code)
+        }
+    }
+}
+
+func TestSorting(t *testing.T) {
+    router := setupRouter()
+    short, long := 80, 140
+    titles := []string{"Sorted A", "Sorted B", "Sorted C", "Sorted D"}
+    runtimes := []*int{&long, nil, &short, nil}
+    var ids []uint
+    for i, title := range titles {
+        movie := models.Movie{Title: title, Year: 2010, Runtime: runtimes[i]}
+        testDB.Omit("Country").Create(&movie)
+        ids = append(ids, movie.ID)
+    }
+    reviewer, _ := signup(router, "sortreviewer", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: ids[0], UserID: reviewer, Rating: 2})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: ids[1], UserID: reviewer, Rating: 8})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: ids[2], UserID: reviewer, Rating: 8})
+
+    type movie struct {
+        ID            uint    `json:"id"`
+        Title         string  `json:"title"`
+        AverageRating float64 `json:"average_rating"`
+    }
+    // walk lists every page of the query with a page size of one, so each
+    // page boundary goes through a cursor.
+    walk := func(t *testing.T, query string) []movie {
+        var all []movie
+        path := "/api/movies?limit=1&" + query
+        for path != "" {
+            resp := doRequest(router, "GET", path, "", "")
+            if resp.Code != http.StatusOK {
+                t.Fatalf("%s: expected status %d but got %d: %s", path, http.StatusOK, resp.Code, resp.Body.String())
+            }
+            var page struct {
+                Data       []movie `json:"data"`
+                Pagination struct {
+                    NextCursor string `json:"next_cursor"`
+                } `json:"pagination"`
+            }
+            json.Unmarshal(resp.Body.Bytes(), &page)
+            all = append(all, page.Data...)
+            path = ""
+            if page.Pagination.NextCursor != "" {
+                path = "/api/movies?limit=1&" + query + "&cursor=" + page.Pagination.NextCursor
+            }
+        }
+        return all
+    }
+    sortedTitles := func(movies []movie) []string {
+        var titles []string
+        for _, m := range movies {
+            if strings.HasPrefix(m.Title, "Sorted ") {
+                titles = append(titles, m.Title)
+            }
+        }
+        return titles
+    }
+
+    t.Run("by rating with tie-break", func(t *testing.T) {
+        movies := walk(t, "year_min=2010&year_max=2010&sort=-average_rating")
+        want := []string{"Sorted B", "Sorted C", "Sorted A", "Sorted D"}
+        if got := sortedTitles(movies); fmt.Sprint(got) != fmt.Sprint(want) {
+            t.Errorf("Expected %v but got %v", want, got)
+        }
+        for i := 1; i < len(movies); i++ {
+            if movies[i].AverageRating > movies[i-1].AverageRating {
+                t.Errorf("movies out of order at %d", i)
+            }
+        }
+    })
+
+    t.Run("nulls last in both directions", func(t *testing.T) {
+        for query, want := range map[string][]string{
+            "sort=runtime,-title": {"Sorted C", "Sorted A", "Sorted D", "Sorted B"},
+            "sort=-runtime,title": {"Sorted A", "Sorted C", "Sorted B", "Sorted D"},
+        } {
+            got := sortedTitles(walk(t, "year_min=2010&year_max=2010&"+query))
+            if fmt.Sprint(got) != fmt.Sprint(want) {
+                t.Errorf("%s: expected %v but got %v", query, want, got)
+            }
+        }
+    })
+
+    t.Run("reviews", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/reviews?sort=-created_at&limit=2", "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var page struct {
+            Data []struct {
+                CreatedAt time.Time `json:"created_at"`
+            } `json:"data"`
+            Pagination struct {
+                NextCursor string `json:"next_cursor"`
+            } `json:"pagination"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        if len(page.Data) != 2 || page.Data[0].CreatedAt.Before(page.Data[1].CreatedAt) {
+            t.Fatalf("Expected newest reviews first but got %s", resp.Body.String())
+        }
+        last := page.Data[1].CreatedAt
+
+        resp = doRequest(router, "GET", "/api/reviews?sort=-created_at&limit=2&cursor="+page.Pagination.NextCursor, "", "")
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        if resp.Code != http.StatusOK || len(page.Data) == 0 || page.Data[0].CreatedAt.After(last) {
+            t.Errorf("Expected the next page to continue after %v but got %s", last, resp.Body.String())
+        }
+
+        resp = doRequest(router, "GET", "/api/reviews?sort=rating&cursor="+page.Pagination.NextCursor, "", "")
+        if page.Pagination.NextCursor != "" && resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d for a cursor of another order but got %d", http.StatusBadRequest, resp.Code)
+        }
+    })
+
+    for _, path := range []string{"/api/movies?sort=director", "/api/movies?sort=year,-year", "/api/reviews?sort=title"} {
+        if resp := doRequest(router, "GET", path, "", ""); resp.Code != http.StatusBadRequest {
+            t.Errorf("%s: expected status %d but got %d", path, http.StatusBadRequest, resp.Code)
+        }
+    }
+}
+
+func TestSearch(t *testing.T) {
+    router := setupRouter()
+    actor := models.Person{Name: "Humphrey Bogartian"}
+    testDB.Create(&actor)
+    description := "A private detective takes on a case involving three eccentric criminals."
+    tagline := "The stuff <dreams> are made of"
+    falcon := models.Movie{Title: "The Maltese Falconry", Year: 1941, Description: &description, Tagline: &tagline}
+    other := models.Movie{Title: "Falconry Detective Story", Year: 1950}
+    testDB.Omit("Country").Create(&falcon)
+    testDB.Omit("Country").Create(&other)
+    testDB.Create(&models.MovieActor{MovieID: falcon.ID, PersonID: actor.ID})
+
+    find := func(t *testing.T, q string) search.Results {
+        resp := doRequest(router, "GET", "/api/search?q="+url.QueryEscape(q), "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("%s: expected status %d but got %d: %s", q, http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var results search.Results
+        json.Unmarshal(resp.Body.Bytes(), &results)
+        return results
+    }
+
+    t.Run("title prefix ranks title matches first", func(t *testing.T) {
+        results := find(t, "falconr")
+        if len(results.Movies) != 2 {
+            t.Fatalf("Expected 2 movies but got %+v", results.Movies)
+        }
+        if !strings.Contains(results.Movies[0].Title, "<mark>") {
+            t.Errorf("Expected a highlighted title but got %q", results.Movies[0].Title)
+        }
+        if results.Movies[0].Score < results.Movies[1].Score {
+            t.Errorf("Expected movies ordered by score but got %+v", results.Movies)
+        }
+    })
+
+    t.Run("words combine with AND", func(t *testing.T) {
+        results := find(t, "falconry detective")
+        if len(results.Movies) != 2 {
+            t.Fatalf("Expected 2 movies but got %+v", results.Movies)
+        }
+        if results.Movies[0].ID != other.ID {
+            t.Errorf("Expected the movie with both words in the title first but got %+v", results.Movies)
+        }
+        if results := find(t, "falconry eccentric"); len(results.Movies) != 1 || results.Movies[0].ID != falcon.ID {
+            t.Errorf("Expected only %d but got %+v", falcon.ID, results.Movies)
+        }
+    })
+
+    t.Run("cast names and people", func(t *testing.T) {
+        results := find(t, "bogartian")
+        if len(results.Movies) != 1 || results.Movies[0].ID != falcon.ID {
+            t.Errorf("Expected the movie of the actor but got %+v", results.Movies)
+        }
+        if len(results.People) != 1 || results.People[0].Name != "Humphrey <mark>Bogartian</mark>" {
+            t.Errorf("Expected the highlighted actor but got %+v", results.People)
+        }
+    })
+
+    t.Run("snippets are escaped", func(t *testing.T) {
+        results := find(t, "dreams")
+        if len(results.Movies) != 1 || !strings.Contains(results.Movies[0].Snippet, "&lt;<mark>dreams</mark>&gt;") {
+            t.Errorf("Expected an escaped snippet but got %+v", results.Movies)
+        }
+    })
+
+    t.Run("index follows changes", func(t *testing.T) {
+        testDB.Model(&actor).Update("name", "Lauren Bacallian")
+        if results := find(t, "bogartian"); len(results.Movies) != 0 || len(results.People) != 0 {
+            t.Errorf("Expected no results for the old name but got %+v", results)
+        }
+        if results := find(t, "bacallian"); len(results.Movies) != 1 || len(results.People) != 1 {
+            t.Errorf("Expected the renamed actor and the movie but got %+v", results)
+        }
+
+        testDB.Delete(&models.MovieActor{}, "movie_id = ? AND person_id = ?", falcon.ID, actor.ID)
+        if results := find(t, "bacallian"); len(results.Movies) != 0 {
+            t.Errorf("Expected no movie after removing the credit but got %+v", results.Movies)
+        }
+
+        testDB.Delete(&other)
+        if results := find(t, "falconry"); len(results.Movies) != 1 {
+            t.Errorf("Expected the deleted movie to disappear but got %+v", results.Movies)
+        }
+    })
+
+    for _, q := range []string{"", "  -- * "} {
+        if resp := doRequest(router, "GET", "/api/search?q="+url.QueryEscape(q), "", ""); resp.Code != http.StatusBadRequest {
+            t.Errorf("%q: expected status %d but got %d", q, http.StatusBadRequest, resp.Code)
+        }
+    }
+}
+
+func TestAutocomplete(t *testing.T) {
+    router := setupRouter()
+    schindler := models.Movie{Title: "Schindler's List", Year: 1993}
+    godfather := models.Movie{Title: "The Godfather", Year: 1972}
+    sequel := models.Movie{Title: "The Godfather Part II", Year: 1974}
+    for _, movie := range []*models.Movie{&schindler, &godfather, &sequel} {
+        testDB.Omit("Country").Create(movie)
+    }
+    coppola := models.Person{Name: "Francis Ford Coppola"}
+    testDB.Create(&coppola)
+
+    reviewer, _ := signup(router, "suggestreviewer", "testpassword")
+    other, _ := signup(router, "suggestreviewer2", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: godfather.ID, UserID: reviewer, Rating: 10})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: godfather.ID, UserID: other, Rating: 9})
+
+    suggest := func(t *testing.T, q string) []search.Suggestion {
+        resp := doRequest(router, "GET", "/api/movies/autocomplete?q="+url.QueryEscape(q), "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("%s: expected status %d but got %d: %s", q, http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var suggestions []search.Suggestion
+        json.Unmarshal(resp.Body.Bytes(), &suggestions)
+        return suggestions
+    }
+
+    t.Run("typos", func(t *testing.T) {
+        if got := suggest(t, "Schindlers Lst"); len(got) == 0 || got[0].ID != schindler.ID || got[0].Type != "movie" {
+            t.Errorf("Expected Schindler's List first but got %+v", got)
+        }
+        got := suggest(t, "godfater")
+        if len(got) < 2 || got[0].ID != godfather.ID || got[1].ID != sequel.ID {
+            t.Fatalf("Expected both Godfather movies, the reviewed one first, but got %+v", got)
+        }
+        if got[0].Distance != 1 || got[0].Popularity != 2 {
+            t.Errorf("Expected distance 1 and popularity 2 but got %+v", got[0])
+        }
+        if got := suggest(t, "copola"); len(got) == 0 || got[0].ID != coppola.ID || got[0].Type != "person" {
+            t.Errorf("Expected Coppola but got %+v", got)
+        }
+    })
+
+    t.Run("prefix", func(t *testing.T) {
+        if got := suggest(t, "godfather part"); len(got) == 0 || got[0].ID != sequel.ID || got[0].Distance != 0 {
+            t.Errorf("Expected the sequel as an exact prefix match but got %+v", got)
+        }
+    })
+
+    t.Run("index follows changes", func(t *testing.T) {
+        testDB.Model(&schindler).Update("title", "Schindler's Ark")
+        if got := suggest(t, "schindlers ark"); len(got) == 0 || got[0].ID != schindler.ID || got[0].Distance != 0 {
+            t.Errorf("Expected the renamed movie but got %+v", got)
+        }
+        testDB.Delete(&schindler)
+        for _, s := range suggest(t, "schindlers ark") {
+            if s.Type == "movie" && s.ID == schindler.ID {
+                t.Errorf("Expected the deleted movie to be gone but got %+v", s)
+            }
+        }
+    })
+
+    t.Run("person popularity follows credits", func(t *testing.T) {
+        popularity := func(t *testing.T) int {
+            got := suggest(t, "copola")
+            if len(got) == 0 || got[0].ID != coppola.ID {
+                t.Fatalf("Expected Coppola but got %+v", got)
+            }
+            return got[0].Popularity
+        }
+        testDB.Create(&models.MovieDirector{MovieID: godfather.ID, PersonID: coppola.ID})
+        credit := models.CrewCredit{MovieID: sequel.ID, PersonID: coppola.ID, Department: models.DepartmentProducer, Job: "Producer"}
+        testDB.Omit("Movie", "Person").Create(&credit)
+        if got := popularity(t); got != 2 {
+            t.Errorf("Expected a directing and a crew credit to count but got popularity %d", got)
+        }
+        testDB.Delete(&credit)
+        if got := popularity(t); got != 1 {
+            t.Errorf("Expected the removed crew credit not to count but got popularity %d", got)
+        }
+    })
+
+    t.Run("index waits for commits", func(t *testing.T) {
+        testDB.Transaction(func(tx *gorm.DB) error {
+            tx.Omit("Country").Create(&models.Movie{Title: "Rolled Back Picture", Year: 2001})
+            return errors.New("roll back")
+        })
+        for _, s := range suggest(t, "rolled back picture") {
+            if s.Text == "Rolled Back Picture" {
+                t.Errorf("Expected the rolled back movie not to be suggested but got %+v", s)
+            }
+        }
+
+        testDB.Transaction(func(tx *gorm.DB) error {
+            renamed := models.Movie{Model: gorm.Model{ID: godfather.ID}}
+            tx.Model(&renamed).Update("title", "The Godfather Saga")
+            return errors.New("roll back")
+        })
+        committed := models.Movie{Title: "Committed Picture", Year: 2002}
+        testDB.Transaction(func(tx *gorm.DB) error {
+            return tx.Omit("Country").Create(&committed).Error
+        })
+        if got := suggest(t, "committed picture"); len(got) == 0 || got[0].ID != committed.ID {
+            t.Errorf("Expected the committed movie but got %+v", got)
+        }
+        if got := suggest(t, "godfather saga"); len(got) > 0 && got[0].Distance == 0 {
+            t.Errorf("Expected the rolled back rename not to be indexed but got %+v", got)
+        }
+    })
+
+    if resp := doRequest(router, "GET", "/api/movies/autocomplete?q=%20-", "", ""); resp.Code != http.StatusBadRequest {
+        t.Errorf("Expected status %d for an empty query but got %d", http.StatusBadRequest, resp.Code)
+    }
+}
+
+func TestMovieFacets(t *testing.T) {
+    router := setupRouter()
+    silent := models.Genre{Name: "Facet Silent"}
+    horror := models.Genre{Name: "Facet Horror"}
+    testDB.Create(&silent)
+    testDB.Create(&horror)
+    country := models.Country{Name: "Facetonia"}
+    testDB.Create(&country)
+    language := models.Language{Name: "Facetese"}
+    testDB.Create(&language)
+
+    first := models.Movie{Title: "Facet One", Year: 1903, CountryID: country.ID}
+    second := models.Movie{Title: "Facet Two", Year: 1915, CountryID: country.ID}
+    third := models.Movie{Title: "Facet Three", Year: 1922}
+    for _, movie := range []*models.Movie{&first, &second, &third} {
+        testDB.Omit("Country").Create(movie)
+    }
+    testDB.Create(&models.MovieGenre{MovieID: first.ID, GenreID: silent.ID})
+    testDB.Create(&models.MovieGenre{MovieID: second.ID, GenreID: silent.ID})
+    testDB.Create(&models.MovieGenre{MovieID: third.ID, GenreID: silent.ID})
+    testDB.Create(&models.MovieGenre{MovieID: third.ID, GenreID: horror.ID})
+    testDB.Exec("INSERT INTO movie_languages (movie_id, language_id) VALUES (?, ?)", second.ID, language.ID)
+
+    reviewer, _ := signup(router, "facetreviewer", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: first.ID, UserID: reviewer, Rating: 7.5})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: second.ID, UserID: reviewer, Rating: 7})
+
+    type facetCount struct {
+        ID    *uint  `json:"id"`
+        Value string `json:"value"`
+        Count int64  `json:"count"`
+    }
+    facets := func(t *testing.T, query string) map[string][]facetCount {
+        resp := doRequest(router, "GET", "/api/movies?year_min=1900&year_max=1929&"+query, "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("%s: expected status %d but got %d: %s", query, http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var page struct {
+            Facets map[string][]facetCount `json:"facets"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        return page.Facets
+    }
+    format := func(counts []facetCount) string {
+        var parts []string
+        for _, c := range counts {
+            parts = append(parts, fmt.Sprintf("%s=%d", c.Value, c.Count))
+        }
+        return strings.Join(parts, " ")
+    }
+
+    got := facets(t, "facets=genre,decade,country,language,rating")
+    for facet, want := range map[string]string{
+        "genre":    "Facet Silent=3 Facet Horror=1",
+        "decade":   "1900=1 1910=1 1920=1",
+        "country":  "Facetonia=2",
+        "language": "Facetese=1",
+        "rating":   "7=2 unrated=1",
+    } {
+        if format(got[facet]) != want {
+            t.Errorf("%s: expected %q but got %q", facet, want, format(got[facet]))
+        }
+    }
+    if genres := got["genre"]; len(genres) > 0 && (genres[0].ID == nil || *genres[0].ID != silent.ID) {
+        t.Errorf("Expected the genre ID with the count but got %+v", genres[0])
+    }
+
+    got = facets(t, "facets=genre,rating&genre=Facet%20Horror")
+    if format(got["genre"]) != "Facet Horror=1 Facet Silent=1" || format(got["rating"]) != "unrated=1" {
+        t.Errorf("Expected facets of the filtered movies but got %+v", got)
+    }
+
+    if got := facets(t, ""); got != nil {
+        t.Errorf("Expected no facets unless asked for but got %+v", got)
+    }
+    if resp := doRequest(router, "GET", "/api/movies?facets=director", "", ""); resp.Code != http.StatusBadRequest {
+        t.Errorf("Expected status %d for an unknown facet but got %d", http.StatusBadRequest, resp.Code)
+    }
+}
+
+func TestUpdateMovie(t *testing.T) {
+    router := setupRouter()
+    curatorID, token := signup(router, "updatecurator", "testpassword")
+    testDB.Model(&models.User{}).Where("id = ?", curatorID).Updates(map[string]interface{}{"role": models.RoleCurator, "email_verified_at": time.Now()})
+
+    drama := models.Genre{Name: "Update Drama"}
+    war := models.Genre{Name: "Update War"}
+    testDB.Create(&drama)
+    testDB.Create(&war)
+    director := models.Person{Name: "Update Director"}
+    testDB.Create(&director)
+
+    runtime := 195
+    tagline := "Whoever saves one life saves the world entire."
+    movie := models.Movie{Title: "Schindlers Lst", Year: 1939, Runtime: &runtime, Tagline: &tagline}
+    testDB.Omit("Country").Create(&movie)
+    testDB.Create(&models.MovieGenre{MovieID: movie.ID, GenreID: drama.ID})
+    other := models.Movie{Title: "Update Other", Year: 1993}
+    testDB.Omit("Country").Create(&other)
+
+    path := fmt.Sprintf("/api/movies/%d", movie.ID)
+    type result struct {
+        Title   string
+        Year    int
+        Runtime *int
+        Tagline *string
+        Genres  []struct{ ID uint }
+        Directors []struct{ ID uint }
+    }
+    update := func(t *testing.T, method, body string, want int) result {
+        resp := doRequest(router, method, path, body, token)
+        if resp.Code != want {
+            t.Fatalf("%s %s: expected status %d but got %d: %s", method, body, want, resp.Code, resp.Body.String())
+        }
+        var got result
+        json.Unmarshal(resp.Body.Bytes(), &got)
+        return got
+    }
+    genreIDs := func(r result) string {
+        var ids []string
+        for _, g := range r.Genres {
+            ids = append(ids, fmt.Sprint(g.ID))
+        }
+        return strings.Join(ids, ",")
+    }
+
+    t.Run("PATCH merges scalar fields", func(t *testing.T) {
+        got := update(t, "PATCH", `{"title": "Schindler's List", "year": 1993}`, http.StatusOK)
+        if got.Title != "Schindler's List" || got.Year != 1993 || got.Runtime == nil || *got.Runtime != 195 {
+            t.Errorf("Expected only title and year to change but got %+v", got)
+        }
+        got = update(t, "PATCH", `{"runtime": null}`, http.StatusOK)
+        if got.Runtime != nil || got.Tagline == nil {
+            t.Errorf("Expected runtime to be cleared but got %+v", got)
+        }
+    })
+
+    t.Run("PATCH associations", func(t *testing.T) {
+        got := update(t, "PATCH", fmt.Sprintf(`{"genre_ids": {"add": [%d], "remove": [%d]}, "director_ids": [%d]}`, war.ID, drama.ID, director.ID), http.StatusOK)
+        if genreIDs(got) != fmt.Sprint(war.ID) || len(got.Directors) != 1 {
+            t.Errorf("Expected genre %d and one director but got %+v", war.ID, got)
+        }
+        got = update(t, "PATCH", fmt.Sprintf(`{"genre_ids": [%d, %d]}`, drama.ID, war.ID), http.StatusOK)
+        if len(got.Genres) != 2 || len(got.Directors) != 1 {
+            t.Errorf("Expected both genres and the director to stay but got %+v", got)
+        }
+    })
+
+    t.Run("PATCH is atomic", func(t *testing.T) {
+        update(t, "PATCH", `{"title": "Half Applied", "genre_ids": [999999]}`, http.StatusBadRequest)
+        var stored models.Movie
+        testDB.First(&stored, movie.ID)
+        if stored.Title != "Schindler's List" {
+            t.Errorf("Expected no change after a failed update but title is %q", stored.Title)
+        }
+    })
+
+    t.Run("PATCH errors", func(t *testing.T) {
+        update(t, "PATCH", `{"title": null}`, http.StatusBadRequest)
+        update(t, "PATCH", `{"year": 1800}`, http.StatusBadRequest)
+        update(t, "PATCH", `{"rating": 10}`, http.StatusBadRequest)
+        update(t, "PATCH", `{"genre_ids": "drama"}`, http.StatusBadRequest)
+        update(t, "PATCH", `{"country_id": 999999}`, http.StatusBadRequest)
+        update(t, "PATCH", `{"title": "update other", "year": 1993}`, http.StatusConflict)
+        if resp := doRequest(router, "PATCH", "/api/movies/999999", `{"year": 2000}`, token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("PUT replaces the movie", func(t *testing.T) {
+        got := update(t, "PUT", fmt.Sprintf(`{"title": "Schindler's List", "year": 1993, "runtime": 195, "genre_ids": [%d]}`, war.ID), http.StatusOK)
+        if got.Tagline != nil || got.Runtime == nil || genreIDs(got) != fmt.Sprint(war.ID) || len(got.Directors) != 0 {
+            t.Errorf("Expected left out fields to be cleared but got %+v", got)
+        }
+        update(t, "PUT", `{"title": "No Year"}`, http.StatusBadRequest)
+    })
+
+    t.Run("requires the curator role", func(t *testing.T) {
+        _, userToken := signup(router, "updateplainuser", "testpassword")
+        if resp := doRequest(router, "PATCH", path, `{"year": 2000}`, userToken); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+}
+
+func TestDeleteMovie(t *testing.T) {
+    router := setupRouter()
+    curatorID, token := signup(router, "deletecurator", "testpassword")
+    testDB.Model(&models.User{}).Where("id = ?", curatorID).Updates(map[string]interface{}{"role": models.RoleCurator, "email_verified_at": time.Now()})
+    _, adminToken := signup(router, "deleteadmin", "testpassword")
+    auth.EnsureAdmin(testDB, "deleteadmin")
+
+    genre := models.Genre{Name: "Delete Genre"}
+    testDB.Create(&genre)
+    actor := models.Person{Name: "Delete Actor"}
+    testDB.Create(&actor)
+    movie := models.Movie{Title: "Deletable Movie", Year: 2001}
+    testDB.Omit("Country").Create(&movie)
+    testDB.Create(&models.MovieGenre{MovieID: movie.ID, GenreID: genre.ID})
+    testDB.Create(&models.MovieActor{MovieID: movie.ID, PersonID: actor.ID})
+    testDB.Create(&models.Role{MovieID: movie.ID, PersonID: actor.ID, Character: "Lead"})
+    reviewer, _ := signup(router, "deletereviewer", "testpassword")
+    review := models.Review{MovieID: movie.ID, UserID: reviewer, Rating: 8, Text: "Deletable review"}
+    testDB.Omit("Movie", "User").Create(&review)
+
+    path := fmt.Sprintf("/api/movies/%d", movie.ID)
+    reviewVisible := func() bool {
+        resp := doRequest(router, "GET", fmt.Sprintf("/api/reviews/%d/", review.ID), "", "")
+        return resp.Code == http.StatusOK
+    }
+    movieVisible := func() bool {
+        resp := doRequest(router, "GET", path+"/", "", "")
+        return resp.Code == http.StatusOK
+    }
+
+    t.Run("unverified curators cannot delete or restore", func(t *testing.T) {
+        resp := doRequest(router, "POST", "/api/users", `{"username": "deleteunverified", "password": "testpassword", "email": "deleteunverified@example.com"}`, "")
+        var created struct {
+            ID    uint   `json:"id"`
+            Token string `json:"token"`
+        }
+        json.Unmarshal(resp.Body.Bytes(), &created)
+        testDB.Model(&models.User{}).Where("id = ?", created.ID).Update("role", models.RoleCurator)
+
+        if resp := doRequest(router, "DELETE", path, "", created.Token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d for DELETE but got %d", http.StatusForbidden, resp.Code)
+        }
+        if resp := doRequest(router, "POST", path+"/restore", "", created.Token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d for restore but got %d", http.StatusForbidden, resp.Code)
+        }
+        if !movieVisible() {
+            t.Errorf("Expected the movie to stay visible")
+        }
+    })
+
+    t.Run("DELETE hides the movie and its reviews", func(t *testing.T) {
+        if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if movieVisible() || reviewVisible() {
+            t.Errorf("Expected the movie and its review to be hidden")
+        }
+        resp := doRequest(router, "GET", "/api/reviews?limit=100&sort=-created_at", "", "")
+        if strings.Contains(resp.Body.String(), "Deletable review") {
+            t.Errorf("Expected the review to be missing from the list")
+        }
+        if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a deleted movie but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("restore", func(t *testing.T) {
+        if resp := doRequest(router, "POST", path+"/restore", "", token); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if !movieVisible() || !reviewVisible() {
+            t.Errorf("Expected the movie and its review to be back")
+        }
+        if resp := doRequest(router, "POST", path+"/restore", "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a movie that is not deleted but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("restore conflicts with a new copy", func(t *testing.T) {
+        doRequest(router, "DELETE", path, "", token)
+        copy := models.Movie{Title: "Deletable Movie", Year: 2001}
+        testDB.Omit("Country").Create(&copy)
+        if resp := doRequest(router, "POST", path+"/restore", "", token); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d but got %d", http.StatusConflict, resp.Code)
+        }
+        testDB.Unscoped().Delete(&copy)
+    })
+
+    t.Run("purge", func(t *testing.T) {
+        if resp := doRequest(router, "DELETE", "/api/admin"+path[4:], "", token); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d for a curator but got %d", http.StatusForbidden, resp.Code)
+        }
+        live := models.Movie{Title: "Live Movie", Year: 2001}
+        testDB.Omit("Country").Create(&live)
+        if resp := doRequest(router, "DELETE", fmt.Sprintf("/api/admin/movies/%d", live.ID), "", adminToken); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d for a movie that is not deleted but got %d", http.StatusConflict, resp.Code)
+        }
+
+        if resp := doRequest(router, "DELETE", "/api/admin"+path[4:], "", adminToken); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        var left int64
+        for _, table := range []string{"movies WHERE id = ?", "movie_genres WHERE movie_id = ?", "movie_actors WHERE movie_id = ?", "roles WHERE movie_id = ?", "reviews WHERE movie_id = ?"} {
+            var n int64
+            testDB.Raw("SELECT COUNT(*) FROM "+table, movie.ID).Scan(&n)
+            left += n
+        }
+        if left != 0 {
+            t.Errorf("Expected every row of the movie to be gone but %d are left", left)
+        }
+        if resp := doRequest(router, "POST", path+"/restore", "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d after purging but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+}
+
+func TestMovieDetails(t *testing.T) {
+    router := setupRouter()
+
+    country := models.Country{Name: "Detail Country"}
+    testDB.Create(&country)
+    director := models.Person{Name: "Detail Director"}
+    writer := models.Person{Name: "Detail Writer"}
+    lead := models.Person{Name: "Detail Lead"}
+    extra := models.Person{Name: "Detail Extra"}
+    for _, person := range []*models.Person{&director, &writer, &lead, &extra} {
+        testDB.Create(person)
+    }
+    runtime, budget, metascore, tagline := 121, int64(5000000), 77, "Every detail counts"
+    movie := models.Movie{
+        Title: "Detailed Movie", Year: 1999, Runtime: &runtime, Budget: &budget, Metascore: &metascore,
+        Tagline: &tagline, CountryID: country.ID,
+        Genres:    []models.Genre{{Name: "Detail Genre"}},
+        Languages: []models.Language{{Name: "Detail Language"}},
+    }
+    testDB.Omit("Country").Create(&movie)
+    testDB.Create(&models.MovieDirector{MovieID: movie.ID, PersonID: director.ID})
+    testDB.Create(&models.MovieWriter{MovieID: movie.ID, PersonID: writer.ID})
+    testDB.Create(&models.MovieActor{MovieID: movie.ID, PersonID: lead.ID})
+    testDB.Create(&models.MovieActor{MovieID: movie.ID, PersonID: extra.ID})
+    role := models.Role{MovieID: movie.ID, PersonID: lead.ID, Character: "The Hero", Position: 1}
+    testDB.Omit("Movie", "Actor").Create(&role)
+    composer := models.Person{Name: "Detail Composer"}
+    testDB.Create(&composer)
+    crew := models.CrewCredit{MovieID: movie.ID, PersonID: composer.ID, Department: models.DepartmentComposer}
+    testDB.Omit("Movie", "Person").Create(&crew)
+    first, _ := signup(router, "detailreviewer1", "testpassword")
+    second, _ := signup(router, "detailreviewer2", "testpassword")
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: movie.ID, UserID: first, Rating: 6, Text: "Fine"})
+    testDB.Omit("Movie", "User").Create(&models.Review{MovieID: movie.ID, UserID: second, Rating: 8, Text: "Good"})
+
+    resp := doRequest(router, "GET", fmt.Sprintf("/api/movies/%d/", movie.ID), "", "")
+    if resp.Code != http.StatusOK {
+        t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+    }
+    var detail handlers.MovieDetailResponse
+    if err := json.Unmarshal(resp.Body.Bytes(), &detail); err != nil {
+        t.Fatalf("Failed to decode the movie: %v", err)
+    }
+
+    if detail.Year != 1999 || detail.Runtime == nil || *detail.Runtime != runtime ||
+        detail.Budget == nil || *detail.Budget != budget || detail.Gross != nil ||
+        detail.Metascore == nil || *detail.Metascore != metascore ||
+        detail.Tagline == nil || *detail.Tagline != tagline {
+        t.Errorf("Expected the movie metadata, got %s", resp.Body.String())
+    }
+    if detail.Country == nil || detail.Country.Name != "Detail Country" {
+        t.Errorf("Expected the country, got %+v", detail.Country)
+    }
+    if len(detail.Genres) != 1 || detail.Genres[0].Name != "Detail Genre" ||
+        len(detail.Languages) != 1 || detail.Languages[0].Name != "Detail Language" {
+        t.Errorf("Expected the genre and language, got %+v and %+v", detail.Genres, detail.Languages)
+    }
+    if len(detail.Directors) != 1 || detail.Directors[0].ID != director.ID ||
+        len(detail.Writers) != 1 || detail.Writers[0].ID != writer.ID {
+        t.Errorf("Expected the director and writer, got %+v and %+v", detail.Directors, detail.Writers)
+    }
+    expectedCast := []handlers.CastCredit{
+        {CreditID: role.ID, ID: lead.ID, Name: "Detail Lead", Character: "The Hero", Position: 1},
+        {ID: extra.ID, Name: "Detail Extra"},
+    }
+    if !reflect.DeepEqual(detail.Cast, expectedCast) {
+        t.Errorf("Expected cast %+v, got %+v", expectedCast, detail.Cast)
+    }
+    expectedCrew := []handlers.CrewCredit{
+        {CreditID: crew.ID, ID: composer.ID, Name: "Detail Composer", Department: models.DepartmentComposer},
+    }
+    if !reflect.DeepEqual(detail.Crew, expectedCrew) {
+        t.Errorf("Expected crew %+v, got %+v", expectedCrew, detail.Crew)
+    }
+    if detail.AverageRating != 7 || detail.RatingCount != 2 || detail.ReviewCount != 2 {
+        t.Errorf("Expected an average of 7 over 2 ratings, got %v over %d", detail.AverageRating, detail.RatingCount)
+    }
+
+    if resp := doRequest(router, "GET", "/api/movies/999999/", "", ""); resp.Code != http.StatusNotFound {
+        t.Errorf("Expected status %d for a missing movie but got %d", http.StatusNotFound, resp.Code)
+    }
+}
+
+func TestCredits(t *testing.T) {
+    router := setupRouter()
+    curatorID, token := signup(router, "creditscurator", "testpassword")
+    testDB.Model(&models.User{}).Where("id = ?", curatorID).Updates(map[string]interface{}{"role": models.RoleCurator, "email_verified_at": time.Now()})
+    _, userToken := signup(router, "creditsuser", "testpassword")
+
+    var people []models.Person
+    for _, name := range []string{"Credits Lead", "Credits Second", "Credits Third", "Credits Producer"} {
+        person := models.Person{Name: name}
+        testDB.Create(&person)
+        people = append(people, person)
+    }
+    lead, second, third, producer := people[0], people[1], people[2], people[3]
+
+    body := fmt.Sprintf(`{"title": "Credits Movie", "year": 2010, "cast": [
+        {"person_id": %d, "character": "Hero"}, {"person_id": %d, "character": "Sidekick"}]}`, lead.ID, second.ID)
+    resp := doRequest(router, "POST", "/api/movies", body, token)
+    if resp.Code != http.StatusCreated {
+        t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+    }
+    var movie struct{ ID uint }
+    json.Unmarshal(resp.Body.Bytes(), &movie)
+    castPath := fmt.Sprintf("/api/movies/%d/cast", movie.ID)
+    crewPath := fmt.Sprintf("/api/movies/%d/crew", movie.ID)
+
+    getCast := func(t *testing.T) []handlers.CastCredit {
+        resp := doRequest(router, "GET", castPath, "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        var cast []handlers.CastCredit
+        json.Unmarshal(resp.Body.Bytes(), &cast)
+        return cast
+    }
+    billing := func(cast []handlers.CastCredit) string {
+        var names []string
+        for i, credit := range cast {
+            if credit.Position != i+1 {
+                return fmt.Sprintf("%s has position %d", credit.Name, credit.Position)
+            }
+            names = append(names, credit.Character)
+        }
+        return strings.Join(names, ",")
+    }
+    creditOf := func(cast []handlers.CastCredit, character string) uint {
+        for _, credit := range cast {
+            if credit.Character == character {
+                return credit.CreditID
+            }
+        }
+        t.Fatalf("No credit for %s in %+v", character, cast)
+        return 0
+    }
+
+    t.Run("cast from the movie request", func(t *testing.T) {
+        if got := billing(getCast(t)); got != "Hero,Sidekick" {
+            t.Errorf("Expected Hero,Sidekick, got %s", got)
+        }
+    })
+
+    t.Run("add at a position", func(t *testing.T) {
+        resp := doRequest(router, "POST", castPath, fmt.Sprintf(`{"person_id": %d, "character": "Villain", "position": 1}`, third.ID), token)
+        if resp.Code != http.StatusCreated {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+        }
+        if got := billing(getCast(t)); got != "Villain,Hero,Sidekick" {
+            t.Errorf("Expected Villain,Hero,Sidekick, got %s", got)
+        }
+
+        for _, bad := range []string{
+            fmt.Sprintf(`{"person_id": %d, "position": 5}`, third.ID),
+            `{"person_id": 999999}`,
+        } {
+            if resp := doRequest(router, "POST", castPath, bad, token); resp.Code != http.StatusBadRequest {
+                t.Errorf("%s: expected status %d but got %d", bad, http.StatusBadRequest, resp.Code)
+            }
+        }
+        if resp := doRequest(router, "POST", castPath, fmt.Sprintf(`{"person_id": %d}`, third.ID), userToken); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d for a user but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
+    t.Run("update and move", func(t *testing.T) {
+        id := creditOf(getCast(t), "Sidekick")
+        resp := doRequest(router, "PATCH", fmt.Sprintf("%s/%d", castPath, id), `{"character": "Partner", "position": 1}`, token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        if got := billing(getCast(t)); got != "Partner,Villain,Hero" {
+            t.Errorf("Expected Partner,Villain,Hero, got %s", got)
+        }
+        if resp := doRequest(router, "PATCH", castPath+"/999999", `{"character": "Nobody"}`, token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a missing credit but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("reorder", func(t *testing.T) {
+        cast := getCast(t)
+        hero, partner, villain := creditOf(cast, "Hero"), creditOf(cast, "Partner"), creditOf(cast, "Villain")
+        resp := doRequest(router, "PUT", castPath, fmt.Sprintf(`{"credit_ids": [%d, %d, %d]}`, hero, partner, villain), token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        if got := billing(getCast(t)); got != "Hero,Partner,Villain" {
+            t.Errorf("Expected Hero,Partner,Villain, got %s", got)
+        }
+        for _, bad := range []string{
+            fmt.Sprintf(`{"credit_ids": [%d, %d]}`, hero, partner),
+            fmt.Sprintf(`{"credit_ids": [%d, %d, %d]}`, hero, hero, villain),
+        } {
+            if resp := doRequest(router, "PUT", castPath, bad, token); resp.Code != http.StatusBadRequest {
+                t.Errorf("%s: expected status %d but got %d", bad, http.StatusBadRequest, resp.Code)
+            }
+        }
+    })
+
+    t.Run("remove", func(t *testing.T) {
+        id := creditOf(getCast(t), "Hero")
+        if resp := doRequest(router, "DELETE", fmt.Sprintf("%s/%d", castPath, id), "", token); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if got := billing(getCast(t)); got != "Partner,Villain" {
+            t.Errorf("Expected Partner,Villain, got %s", got)
+        }
+        var actors int64
+        testDB.Model(&models.MovieActor{}).Where("movie_id = ? AND person_id = ?", movie.ID, lead.ID).Count(&actors)
+        if actors != 0 {
+            t.Errorf("Expected the actor without credits to be removed from the movie")
+        }
+        if resp := doRequest(router, "DELETE", fmt.Sprintf("%s/%d", castPath, id), "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a removed credit but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("actor_ids drops roles", func(t *testing.T) {
+        path := fmt.Sprintf("/api/movies/%d", movie.ID)
+        resp := doRequest(router, "PATCH", path, fmt.Sprintf(`{"actor_ids": {"remove": [%d]}}`, second.ID), token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        if got := billing(getCast(t)); got != "Villain" {
+            t.Errorf("Expected Villain, got %s", got)
+        }
+    })
+
+    t.Run("crew", func(t *testing.T) {
+        body := fmt.Sprintf(`{"person_id": %d, "department": "producer", "job": "Executive Producer"}`, producer.ID)
+        resp := doRequest(router, "POST", crewPath, body, token)
+        if resp.Code != http.StatusCreated {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+        }
+        var credit handlers.CrewCredit
+        json.Unmarshal(resp.Body.Bytes(), &credit)
+        if credit.ID != producer.ID || credit.Department != models.DepartmentProducer || credit.Job != "Executive Producer" {
+            t.Errorf("Unexpected credit %+v", credit)
+        }
+
+        if resp := doRequest(router, "POST", crewPath, body, token); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d for a duplicate credit but got %d", http.StatusConflict, resp.Code)
+        }
+        bad := fmt.Sprintf(`{"person_id": %d, "department": "catering"}`, producer.ID)
+        if resp := doRequest(router, "POST", crewPath, bad, token); resp.Code != http.StatusBadRequest {
+            t.Errorf("Expected status %d for an unknown department but got %d", http.StatusBadRequest, resp.Code)
+        }
+
+        creditPath := fmt.Sprintf("%s/%d", crewPath, credit.CreditID)
+        resp = doRequest(router, "PATCH", creditPath, `{"department": "composer", "job": ""}`, token)
+        if resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+
+        resp = doRequest(router, "GET", crewPath, "", "")
+        var crew []handlers.CrewCredit
+        json.Unmarshal(resp.Body.Bytes(), &crew)
+        if len(crew) != 1 || crew[0].Department != models.DepartmentComposer || crew[0].Job != "" {
+            t.Errorf("Expected one composer, got %+v", crew)
+        }
+
+        if resp := doRequest(router, "DELETE", creditPath, "", token); resp.Code != http.StatusOK {
+            t.Errorf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "DELETE", creditPath, "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a removed credit but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+}
+
+func TestReferences(t *testing.T) {
+    router := setupRouter()
+    curatorID, token := signup(router, "refcurator", "testpassword")
+    testDB.Model(&models.User{}).Where("id = ?", curatorID).Updates(map[string]interface{}{"role": models.RoleCurator, "email_verified_at": time.Now()})
+    _, userToken := signup(router, "refuser", "testpassword")
+
+    create := func(t *testing.T, path, name string, want int) handlers.ReferenceResponse {
+        resp := doRequest(router, "POST", path, fmt.Sprintf(`{"name": %q}`, name), token)
+        if resp.Code != want {
+            t.Fatalf("POST %s %q: expected status %d but got %d: %s", path, name, want, resp.Code, resp.Body.String())
+        }
+        var ref handlers.ReferenceResponse
+        json.Unmarshal(resp.Body.Bytes(), &ref)
+        return ref
+    }
+    get := func(t *testing.T, path string) handlers.ReferenceResponse {
+        resp := doRequest(router, "GET", path, "", "")
+        if resp.Code != http.StatusOK {
+            t.Fatalf("GET %s: expected status %d but got %d", path, http.StatusOK, resp.Code)
+        }
+        var ref handlers.ReferenceResponse
+        json.Unmarshal(resp.Body.Bytes(), &ref)
+        return ref
+    }
+
+    noir := create(t, "/api/genres", "Ref Noir", http.StatusCreated)
+    create(t, "/api/genres", "Ref Western", http.StatusCreated)
+    country := create(t, "/api/countries", "Ref Country", http.StatusCreated)
+    language := create(t, "/api/languages", "Ref Language", http.StatusCreated)
+    star := create(t, "/api/people", "Ref Star", http.StatusCreated)
+    support := create(t, "/api/people", "Ref Support", http.StatusCreated)
+
+    t.Run("create validation", func(t *testing.T) {
+        create(t, "/api/genres", "ref noir", http.StatusConflict)
+        create(t, "/api/countries", "Ref Country", http.StatusConflict)
+        create(t, "/api/genres", "   ", http.StatusBadRequest)
+        create(t, "/api/languages", strings.Repeat("x", 51), http.StatusBadRequest)
+        first := create(t, "/api/people", "Ref Same Name", http.StatusCreated)
+        second := create(t, "/api/people", "Ref Same Name", http.StatusCreated)
+        if first.ID == second.ID {
+            t.Errorf("Expected two people with the same name")
+        }
+        if resp := doRequest(router, "POST", "/api/genres", `{"name": "Ref User Genre"}`, userToken); resp.Code != http.StatusForbidden {
+            t.Errorf("Expected status %d for a user but got %d", http.StatusForbidden, resp.Code)
+        }
+    })
+
+    t.Run("list and get", func(t *testing.T) {
+        resp := doRequest(router, "GET", "/api/genres?q=REF%20", "", "")
+        var page handlers.ListResponse[handlers.ReferenceResponse]
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        var names []string
+        for _, ref := range page.Data {
+            names = append(names, ref.Name)
+        }
+        if strings.Join(names, ",") != "Ref Noir,Ref Western" || page.Pagination.Total != 2 {
+            t.Errorf("Expected Ref Noir,Ref Western, got %v", names)
+        }
+        if ref := get(t, fmt.Sprintf("/api/genres/%d", noir.ID)); ref.Name != "Ref Noir" || ref.MovieCount != 0 {
+            t.Errorf("Unexpected genre %+v", ref)
+        }
+        if resp := doRequest(router, "GET", "/api/genres/999999", "", ""); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+
+    t.Run("rename", func(t *testing.T) {
+        path := fmt.Sprintf("/api/genres/%d", noir.ID)
+        if resp := doRequest(router, "PUT", path, `{"name": "Ref Western"}`, token); resp.Code != http.StatusConflict {
+            t.Errorf("Expected status %d but got %d", http.StatusConflict, resp.Code)
+        }
+        if resp := doRequest(router, "PUT", path, `{"name": "Ref Film Noir"}`, token); resp.Code != http.StatusOK {
+            t.Fatalf("Expected status %d but got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+        }
+        if ref := get(t, path); ref.Name != "Ref Film Noir" {
+            t.Errorf("Expected the new name, got %q", ref.Name)
+        }
+    })
+
+    body := fmt.Sprintf(`{"title": "Ref Movie", "year": 1950, "genre_ids": [%d], "country_id": %d, "language_ids": [%d],
+        "director_ids": [%d], "cast": [{"person_id": %d, "character": "Lead"}, {"person_id": %d, "character": "Friend"}]}`,
+        noir.ID, country.ID, language.ID, star.ID, star.ID, support.ID)
+    resp := doRequest(router, "POST", "/api/movies", body, token)
+    if resp.Code != http.StatusCreated {
+        t.Fatalf("Expected status %d but got %d: %s", http.StatusCreated, resp.Code, resp.Body.String())
+    }
+    var movie struct{ ID uint }
+    json.Unmarshal(resp.Body.Bytes(), &movie)
+    detail := func(t *testing.T) handlers.MovieDetailResponse {
+        resp := doRequest(router, "GET", fmt.Sprintf("/api/movies/%d/", movie.ID), "", "")
+        var detail handlers.MovieDetailResponse
+        json.Unmarshal(resp.Body.Bytes(), &detail)
+        return detail
+    }
+
+    t.Run("movie count", func(t *testing.T) {
+        if ref := get(t, fmt.Sprintf("/api/people/%d", star.ID)); ref.MovieCount != 1 {
+            t.Errorf("Expected the star in 1 movie, got %d", ref.MovieCount)
+        }
+        resp := doRequest(router, "GET", "/api/genres?q=ref&sort=-movie_count", "", "")
+        var page handlers.ListResponse[handlers.ReferenceResponse]
+        json.Unmarshal(resp.Body.Bytes(), &page)
+        if len(page.Data) != 2 || page.Data[0].ID != noir.ID || page.Data[0].MovieCount != 1 {
+            t.Errorf("Expected the used genre first, got %+v", page.Data)
+        }
+    })
+
+    t.Run("delete is blocked while movies refer to the row", func(t *testing.T) {
+        for _, path := range []string{
+            fmt.Sprintf("/api/genres/%d", noir.ID),
+            fmt.Sprintf("/api/countries/%d", country.ID),
+            fmt.Sprintf("/api/people/%d", star.ID),
+        } {
+            if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusConflict {
+                t.Errorf("DELETE %s: expected status %d but got %d", path, http.StatusConflict, resp.Code)
+            }
+        }
+        if len(detail(t).Genres) != 1 {
+            t.Errorf("Expected the genre to stay")
+        }
+    })
+
+    t.Run("cascade", func(t *testing.T) {
+        for _, path := range []string{
+            fmt.Sprintf("/api/genres/%d?cascade=true", noir.ID),
+            fmt.Sprintf("/api/countries/%d?cascade=true", country.ID),
+            fmt.Sprintf("/api/people/%d?cascade=true", star.ID),
+        } {
+            if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusOK {
+                t.Errorf("DELETE %s: expected status %d but got %d: %s", path, http.StatusOK, resp.Code, resp.Body.String())
+            }
+        }
+
+        got := detail(t)
+        if len(got.Genres) != 0 || got.Country != nil || len(got.Directors) != 0 || len(got.Languages) != 1 {
+            t.Errorf("Expected the genre, country and director to be removed, got %+v", got)
+        }
+        if len(got.Cast) != 1 || got.Cast[0].Character != "Friend" || got.Cast[0].Position != 1 {
+            t.Errorf("Expected the remaining credit to move up, got %+v", got.Cast)
+        }
+        if resp := doRequest(router, "GET", fmt.Sprintf("/api/people/%d", star.ID), "", ""); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d for a deleted person but got %d", http.StatusNotFound, resp.Code)
+        }
+
+        // Deleted names can be used again.
+        create(t, "/api/genres", "Ref Film Noir", http.StatusCreated)
+    })
+
+    t.Run("unused rows delete without cascade", func(t *testing.T) {
+        musical := create(t, "/api/genres", "Ref Musical", http.StatusCreated)
+        path := fmt.Sprintf("/api/genres/%d", musical.ID)
+        if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusOK {
+            t.Errorf("Expected status %d but got %d", http.StatusOK, resp.Code)
+        }
+        if resp := doRequest(router, "DELETE", path, "", token); resp.Code != http.StatusNotFound {
+            t.Errorf("Expected status %d but got %d", http.StatusNotFound, resp.Code)
+        }
+    })
+}
@@ -0,0 +1,89 @@
+// Command breachlist builds a breached password list for the API from
+// plain-text password lists or from SHA-1 corpora such as the "Pwned
+// Passwords" download (lines of HEX:count).
+//
+//	breachlist -o breached.bin rockyou.txt
+//	breachlist -sha1 -o breached.bin pwned-passwords-sha1-ordered-by-hash.txt
+//
+// All entries are sorted in memory, at 8 bytes per password.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"movie-api/internal/breach"
+	"os"
+	"strings"
+)
+
+func main() {
+	output := flag.String("o", "breached.bin", "list file to write")
+	sha1Input := flag.Bool("sha1", false, "input lines are hex SHA-1 hashes, optionally followed by :count")
+	flag.Parse()
+
+	var keys []uint64
+	read := func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if line == "" {
+				continue
+			}
+			if !*sha1Input {
+				keys = append(keys, breach.Key(line))
+				continue
+			}
+			digest, _, _ := strings.Cut(line, ":")
+			key, err := breach.KeyFromSHA1(strings.TrimSpace(digest))
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		return scanner.Err()
+	}
+
+	if flag.NArg() == 0 {
+		if err := read(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := read(f); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		f.Close()
+	}
+
+	// Write to a temporary file and rename, so a running API reloading the
+	// list never sees a partial file.
+	tmp := *output + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := breach.Write(f, keys); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp, *output); err != nil {
+		log.Fatal(err)
+	}
+
+	list, err := breach.Open(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %d passwords to %s\n", list.Len(), *output)
+	list.Close()
+}
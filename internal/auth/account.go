@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"fmt"
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DeleteAccount godoc
+// @Summary Delete own account
+// @Description Delete the account of the current user. The user is signed out everywhere and the account can be restored by an admin until the grace period ends; after that it is purged. Reviews are either anonymized or deleted with the account.
+// @Tags users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.DeleteAccountRequest true "What to do with the reviews, and the current password"
+// @Success 202 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me [delete]
+func DeleteAccount(db *gorm.DB, grace time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.DeleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		// Accounts created through single sign-on have no password to confirm.
+		if user.Password != "" {
+			if ok, _ := verifyPassword(user.Password, req.Password); !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+				return
+			}
+		}
+
+		now := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := revokeAllSessions(tx, user.ID); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.APIKey{}).
+				Where("user_id = ? AND revoked_at IS NULL", user.ID).
+				Update("revoked_at", now).Error; err != nil {
+				return err
+			}
+			// Deleted reviews are hidden right away. They share the deletion
+			// time of the account so a restore brings back exactly these.
+			if req.Reviews == models.DeletionDelete {
+				if err := tx.Model(&models.Review{}).
+					Where("user_id = ?", user.ID).
+					Update("deleted_at", now).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&user).Updates(map[string]interface{}{
+				"deletion_mode": req.Reviews,
+				"deleted_at":    now,
+			}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":     "account deleted",
+			"purge_after": now.Add(grace),
+		})
+	}
+}
+
+// RestoreAccount godoc
+// @Summary Restore a deleted account
+// @Description Undo an account deletion that has not been purged yet. Reviews deleted with the account come back; revoked sessions and API keys do not.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/users/{id}/restore [post]
+func RestoreAccount(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var user models.User
+		if err := db.Unscoped().
+			Where("id = ? AND deleted_at IS NOT NULL AND deletion_mode IN ?", id,
+				[]models.DeletionMode{models.DeletionAnonymize, models.DeletionDelete}).
+			First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No restorable account found"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if user.DeletionMode == models.DeletionDelete {
+				if err := tx.Unscoped().Model(&models.Review{}).
+					Where("user_id = ? AND deleted_at = ?", user.ID, user.DeletedAt.Time).
+					Update("deleted_at", nil).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Unscoped().Model(&user).Updates(map[string]interface{}{
+				"deletion_mode": "",
+				"deleted_at":    nil,
+			}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "account restored"})
+	}
+}
+
+// PurgeDeletedAccounts permanently removes accounts whose grace period has
+// ended. Anonymized accounts keep a row stripped of personal data as author
+// of their reviews; all other data of the user is hard-deleted, including
+// their soft-deleted reviews. Reviews of other users are left alone.
+func PurgeDeletedAccounts(db *gorm.DB, grace time.Duration) error {
+	cutoff := time.Now().Add(-grace)
+
+	var users []models.User
+	if err := db.Unscoped().
+		Where("deleted_at < ? AND deletion_mode IN ?", cutoff,
+			[]models.DeletionMode{models.DeletionAnonymize, models.DeletionDelete}).
+		Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return purgeAccount(tx, user)
+		}); err != nil {
+			return fmt.Errorf("purge user %d: %w", user.ID, err)
+		}
+	}
+	return nil
+}
+
+func purgeAccount(tx *gorm.DB, user models.User) error {
+	for _, model := range []interface{}{
+		&models.RefreshToken{},
+		&models.APIKey{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.AuthEvent{},
+	} {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("username = ?", user.Username).Delete(&models.LockoutEvent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("username = ?", user.Username).Delete(&models.AuthEvent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("throttle_key = ?", "user:"+user.Username).Delete(&models.LoginThrottle{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", user.ID).Delete(&models.Review{}).Error; err != nil {
+		return err
+	}
+
+	if user.DeletionMode == models.DeletionDelete {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Review{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&user).Error
+	}
+
+	return tx.Unscoped().Model(&user).Updates(map[string]interface{}{
+		"username":      fmt.Sprintf("deleted-%d", user.ID),
+		"email":         nil,
+		"password":      "",
+		"display_name":  "",
+		"bio":           "",
+		"avatar_url":    "",
+		"oidc_issuer":   nil,
+		"oidc_subject":  nil,
+		"totp_secret":   nil,
+		"totp_enabled":  false,
+		"deletion_mode": models.DeletionAnonymized,
+	}).Error
+}
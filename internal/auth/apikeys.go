@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeMoviesWrite     = "movies:write"
+	ScopeReviewsWrite    = "reviews:write"
+	ScopeReviewsModerate = "reviews:moderate"
+	ScopeAdmin           = "admin"
+
+	apiKeyPrefix = "mk_"
+	// lastUsedResolution limits how often a busy key writes its last-used time.
+	lastUsedResolution = time.Minute
+)
+
+// validScopes are the scopes a key can be granted. Reading the catalog and
+// reviews needs no authentication, so there are no read scopes.
+var validScopes = map[string]bool{
+	ScopeMoviesWrite:     true,
+	ScopeReviewsWrite:    true,
+	ScopeReviewsModerate: true,
+	ScopeAdmin:           true,
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, " ")
+}
+
+func toAPIKeyResponse(key models.APIKey) models.APIKeyResponse {
+	return models.APIKeyResponse{
+		ID:         key.ID,
+		UserID:     key.UserID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     splitScopes(key.Scopes),
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+// apiKeyFromRequest returns the API key sent either in X-API-Key or as an
+// "ApiKey" Authorization scheme.
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "ApiKey "); ok {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+func authenticateAPIKey(c *gin.Context, db *gorm.DB, rawKey string) {
+	var key models.APIKey
+	if err := db.Preload("User").Where("key_hash = ?", hashToken(rawKey)).First(&key).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key revoked"})
+		return
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key expired"})
+		return
+	}
+	if key.User.ID == 0 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		db.Model(&models.APIKey{}).Where("id = ?", key.ID).Update("last_used_at", now)
+	}
+
+	c.Set("user", key.User)
+	c.Set("api_key", key)
+	c.Next()
+}
+
+// RequireScope restricts API key requests to keys carrying the scope.
+// Requests authenticated with a user session are not affected.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, viaKey := c.Get("api_key")
+		if !viaKey {
+			c.Next()
+			return
+		}
+
+		for _, granted := range splitScopes(value.(models.APIKey).Scopes) {
+			if granted == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+	}
+}
+
+// RequireSession rejects API keys on routes that manage credentials, so a
+// leaked key cannot be used to mint more keys.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, viaKey := c.Get("api_key"); viaKey {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a user session"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Description Create a scoped API key for the current user, or for another account when called by an admin. The key is only shown once.
+// @Tags api-keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param key body models.CreateAPIKeyRequest true "Key settings"
+// @Success 201 {object} models.APIKeyResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api-keys [post]
+func CreateAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, scope := range req.Scopes {
+			if !validScopes[scope] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope " + scope})
+				return
+			}
+		}
+
+		owner := c.MustGet("user").(models.User)
+		if req.UserID != nil && *req.UserID != owner.ID {
+			if owner.Role != models.RoleAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only admins can create keys for other accounts"})
+				return
+			}
+			owner = models.User{}
+			if err := db.First(&owner, *req.UserID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+		}
+
+		secret, err := randomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+			return
+		}
+		rawKey := apiKeyPrefix + secret
+
+		key := models.APIKey{
+			UserID:  owner.ID,
+			Name:    req.Name,
+			Prefix:  rawKey[:len(apiKeyPrefix)+8],
+			KeyHash: hashToken(rawKey),
+			Scopes:  strings.Join(req.Scopes, " "),
+		}
+		if req.ExpiresInDays > 0 {
+			expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			key.ExpiresAt = &expires
+		}
+
+		if err := db.Create(&key).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+			return
+		}
+
+		response := toAPIKeyResponse(key)
+		response.Key = rawKey
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description List the API keys of the current user. Admins may pass user_id to list another account's keys.
+// @Tags api-keys
+// @Security BearerAuth
+// @Produce json
+// @Param user_id query int false "Owner of the keys (admins only)"
+// @Success 200 {array} models.APIKeyResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api-keys [get]
+func ListAPIKeys(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		ownerID := user.ID
+
+		if param := c.Query("user_id"); param != "" {
+			id, err := strconv.Atoi(param)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			if uint(id) != user.ID && user.Role != models.RoleAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only admins can list keys of other accounts"})
+				return
+			}
+			ownerID = uint(id)
+		}
+
+		var keys []models.APIKey
+		if err := db.Where("user_id = ?", ownerID).Order("id").Find(&keys).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+			return
+		}
+
+		response := make([]models.APIKeyResponse, 0, len(keys))
+		for _, key := range keys {
+			response = append(response, toAPIKeyResponse(key))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Description Revoke an API key of the current user (admins may revoke any key)
+// @Tags api-keys
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} models.APIKeyResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api-keys/{id} [delete]
+func RevokeAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		query := db.Where("id = ?", id)
+		if user.Role != models.RoleAdmin {
+			query = query.Where("user_id = ?", user.ID)
+		}
+
+		var key models.APIKey
+		if err := query.First(&key).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+
+		if key.RevokedAt == nil {
+			now := time.Now()
+			if err := db.Model(&key).Update("revoked_at", now).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+				return
+			}
+			key.RevokedAt = &now
+		}
+
+		c.JSON(http.StatusOK, toAPIKeyResponse(key))
+	}
+}
+
+// CreateServiceAccount godoc
+// @Summary Create service account
+// @Description Create a password-less account that authenticates with API keys
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param account body models.CreateServiceAccountRequest true "Service account"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/service-accounts [post]
+func CreateServiceAccount(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.CreateServiceAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Role == "" {
+			req.Role = models.RoleUser
+		}
+		if !req.Role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+			return
+		}
+
+		var existing models.User
+		if db.Where("username = ?", req.Username).Limit(1).Find(&existing).RowsAffected > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
+			return
+		}
+
+		account := models.User{
+			Username:       req.Username,
+			Role:           req.Role,
+			ServiceAccount: true,
+		}
+		if err := db.Create(&account).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create service account"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"id":       account.ID,
+			"username": account.Username,
+			"role":     account.Role,
+		})
+	}
+}
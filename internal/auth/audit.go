@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"log"
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// sessionTouchInterval limits how often last_seen_at is written while
+	// a session is in use.
+	sessionTouchInterval = time.Minute
+	maxAuditEvents       = 500
+)
+
+func clientUserAgent(c *gin.Context) string {
+	ua := c.Request.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	return ua
+}
+
+// recordAuthEvent stores an audit record with the client address and user
+// agent of the request. A failed write is logged but never fails the
+// request being audited.
+func recordAuthEvent(db *gorm.DB, c *gin.Context, event models.AuthEvent) {
+	event.IP = c.ClientIP()
+	event.UserAgent = clientUserAgent(c)
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("auth: failed to record %s event: %v", event.Event, err)
+	}
+}
+
+func sessionID(claims jwt.MapClaims) (uint, bool) {
+	sid, ok := claims["sid"].(float64)
+	return uint(sid), ok
+}
+
+// revokeSession ends the session together with its refresh token family.
+// Access tokens of the session are refused from then on.
+func revokeSession(tx *gorm.DB, session models.Session) error {
+	if err := tx.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", session.ID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return revokeFamily(tx, session.FamilyID)
+}
+
+func touchSession(db *gorm.DB, claims jwt.MapClaims) {
+	sid, ok := sessionID(claims)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	db.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", sid, now.Add(-sessionTouchInterval)).
+		Update("last_seen_at", now)
+}
+
+// ListSessions godoc
+// @Summary List sessions
+// @Description List the active sign-ins of the current user with where they were started and last used
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.SessionResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/sessions [get]
+func ListSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		current, _ := sessionID(c.MustGet("claims").(jwt.MapClaims))
+
+		var sessions []models.Session
+		if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+			Order("last_seen_at DESC").
+			Find(&sessions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+			return
+		}
+
+		response := make([]models.SessionResponse, 0, len(sessions))
+		for _, session := range sessions {
+			response = append(response, models.SessionResponse{
+				ID:         session.ID,
+				IP:         session.IP,
+				UserAgent:  session.UserAgent,
+				CreatedAt:  session.CreatedAt,
+				LastSeenAt: session.LastSeenAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.ID == current,
+			})
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// RevokeSessionHandler godoc
+// @Summary Revoke a session
+// @Description Sign out one session of the current user. Its refresh and access tokens stop working immediately.
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/sessions/{id} [delete]
+func RevokeSessionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		var session models.Session
+		if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, user.ID).First(&session).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return revokeSession(tx, session)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+		recordAuthEvent(db, c, models.AuthEvent{
+			Event:     models.EventSessionRevoked,
+			UserID:    &user.ID,
+			Username:  user.Username,
+			SessionID: &session.ID,
+		})
+
+		c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+	}
+}
+
+// ListAuthEvents godoc
+// @Summary Query the authentication audit log
+// @Description Newest events first. All filters are optional; since and until are RFC 3339 timestamps.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param user_id query int false "User ID"
+// @Param username query string false "Username, including failed attempts for unknown accounts"
+// @Param event query string false "Event type, e.g. login_failure"
+// @Param ip query string false "Client IP"
+// @Param since query string false "Earliest time"
+// @Param until query string false "Latest time"
+// @Param limit query int false "Maximum number of events (default 100, at most 500)"
+// @Param offset query int false "Number of events to skip"
+// @Success 200 {array} models.AuthEventResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/audit [get]
+func ListAuthEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Model(&models.AuthEvent{})
+
+		if userID := c.Query("user_id"); userID != "" {
+			id, err := strconv.Atoi(userID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+				return
+			}
+			query = query.Where("user_id = ?", id)
+		}
+		if username := c.Query("username"); username != "" {
+			query = query.Where("username = ?", username)
+		}
+		if event := c.Query("event"); event != "" {
+			query = query.Where("event = ?", event)
+		}
+		if ip := c.Query("ip"); ip != "" {
+			query = query.Where("ip = ?", ip)
+		}
+		for param, op := range map[string]string{"since": ">=", "until": "<="} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + ", expected RFC 3339"})
+				return
+			}
+			query = query.Where("created_at "+op+" ?", at)
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > maxAuditEvents {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+
+		var events []models.AuthEvent
+		if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+			return
+		}
+
+		response := make([]models.AuthEventResponse, 0, len(events))
+		for _, event := range events {
+			response = append(response, models.AuthEventResponse{
+				ID:        event.ID,
+				CreatedAt: event.CreatedAt,
+				Event:     event.Event,
+				UserID:    event.UserID,
+				Username:  event.Username,
+				SessionID: event.SessionID,
+				IP:        event.IP,
+				UserAgent: event.UserAgent,
+				Detail:    event.Detail,
+			})
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -2,20 +2,19 @@ package auth
 
 import (
 	"fmt"
+	"log"
+	"movie-api/internal/mail"
 	"movie-api/internal/models"
 	"net/http"
-	"time"
+	"strings"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-const JWTSecret = "your-secret-key"
-
 // LoginHandler godoc
 // @Summary User login
-// @Description Authenticate user and get JWT token
+// @Description Authenticate user and get JWT token. Accounts with two-factor authentication get an MFA challenge (models.MFAChallengeResponse) instead, to be completed at /token/mfa.
 // @Tags authentication
 // @Accept json
 // @Produce json
@@ -23,6 +22,7 @@ const JWTSecret = "your-secret-key"
 // @Success 200 {object} models.TokenResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Router /token [post]
 func LoginHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -36,40 +36,85 @@ func LoginHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var user models.User
-		if err := db.Where("username = ?", creds.Username).First(&user).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		ip := c.ClientIP()
+		if wait := loginRetryAfter(db, creds.Username, ip); wait > 0 {
+			recordAuthEvent(db, c, models.AuthEvent{Event: models.EventLoginThrottled, Username: creds.Username})
+			setRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts"})
 			return
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
+		var user models.User
+		var valid, rehash bool
+		if err := db.Where("username = ? AND service_account = ?", creds.Username, false).First(&user).Error; err == nil {
+			valid, rehash = verifyPassword(user.Password, creds.Password)
+		}
+		if !valid {
+			event := models.AuthEvent{Event: models.EventLoginFailure, Username: creds.Username}
+			if user.ID != 0 {
+				event.UserID = &user.ID
+			}
+			recordAuthEvent(db, c, event)
+			if wait := recordLoginFailure(db, creds.Username, ip); wait > 0 {
+				setRetryAfter(c, wait)
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
+		clearLoginFailures(db, creds.Username)
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub": user.ID,
-			"exp": time.Now().Add(time.Hour * 24).Unix(),
-		})
+		// Upgrade hashes made with an older algorithm or weaker parameters
+		// while the plain password is at hand.
+		if rehash {
+			if hashed, err := hashPassword(creds.Password); err == nil {
+				db.Model(&user).Update("password", hashed)
+			}
+		}
+
+		finishLogin(db, c, user, "")
+	}
+}
 
-		tokenString, err := token.SignedString([]byte(JWTSecret))
+// finishLogin answers a login whose first factor passed: with an MFA
+// challenge when the user has TOTP enabled, otherwise with a token pair.
+// Every way of signing in goes through it, so none can skip the second
+// factor. detail is recorded with the audit events.
+func finishLogin(db *gorm.DB, c *gin.Context, user models.User, detail string) {
+	if user.TOTPEnabled {
+		recordAuthEvent(db, c, models.AuthEvent{Event: models.EventMFAChallenge, UserID: &user.ID, Username: user.Username, Detail: detail})
+		mfaToken, err := issueMFAToken(user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 			return
 		}
+		c.JSON(http.StatusOK, models.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(mfaTokenTTL.Seconds()),
+		})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	recordAuthEvent(db, c, models.AuthEvent{Event: models.EventLoginSuccess, UserID: &user.ID, Username: user.Username, Detail: detail})
+	tokens, err := issueTokenPair(db, c, user, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
+
+	c.JSON(http.StatusOK, tokens)
 }
 
 type CreateUserRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
-	Password string `json:"password" binding:"required,min=6"`
+	// Password must satisfy the password policy, see PasswordPolicy.
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email,omitempty" binding:"omitempty,email"`
 }
 
 // CreateUser godoc
 // @Summary Register new user
-// @Description Create a user account
+// @Description Create a user account. When an email address is given a verification token is mailed to it; the account cannot post until the address is verified.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -79,7 +124,7 @@ type CreateUserRequest struct {
 // @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users [post]	
-func CreateUser(db *gorm.DB) gin.HandlerFunc {
+func CreateUser(db *gorm.DB, mailer mail.Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req CreateUserRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -87,15 +132,27 @@ func CreateUser(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Accounts waiting for deletion still hold their username and email.
 		var existingUser models.User
-        result := db.Where("username = ?", req.Username).Limit(1).Find(&existingUser)
+        result := db.Unscoped().Where("username = ?", req.Username).Limit(1).Find(&existingUser)
 
 		if result.RowsAffected > 0 {
             c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
             return
         }
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if req.Email != "" {
+			if db.Unscoped().Where("email = ?", req.Email).Limit(1).Find(&existingUser).RowsAffected > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+				return
+			}
+		}
+
+		if !checkNewPassword(c, req.Username, req.Password) {
+			return
+		}
+
+		hashedPassword, err := hashPassword(req.Password)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 			return
@@ -103,8 +160,10 @@ func CreateUser(db *gorm.DB) gin.HandlerFunc {
 
 		newUser := models.User{
 			Username: req.Username,
-			Password: string(hashedPassword),
-			Token: "0",
+			Password: hashedPassword,
+		}
+		if req.Email != "" {
+			newUser.Email = &req.Email
 		}
 
 		if err := db.Create(&newUser).Error; err != nil {
@@ -112,44 +171,56 @@ func CreateUser(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub": newUser.ID,
-			"exp": time.Now().Add(time.Hour * 24).Unix(),
-		})
+		// The account exists either way; a failed email can be resent.
+		if newUser.Email != nil {
+			if err := SendEmailVerification(db, mailer, newUser); err != nil {
+				log.Printf("auth: failed to send verification email to user %d: %v", newUser.ID, err)
+			}
+		}
 
-		tokenString, err := token.SignedString([]byte(JWTSecret))
+		tokens, err := issueTokenPair(db, c, newUser, "")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 			return
 		}
 
-		newUser.Token = tokenString
-
-		if err := db.Save(&newUser).Error; err != nil { 
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user with token"})
-			return
-		}
-
 		c.JSON(http.StatusCreated, gin.H{
-			"id":       newUser.ID,
-			"username": newUser.Username,
-			"token":    newUser.Token,
-			"message":  "user created successfully",
+			"id":             newUser.ID,
+			"username":       newUser.Username,
+			"token":          tokens.Token,
+			"refresh_token":  tokens.RefreshToken,
+			"expires_in":     tokens.ExpiresIn,
+			"email_verified": false,
+			"message":        "user created successfully",
 		})
 	}
 }
 
+// bearerToken strips the optional "Bearer " scheme from an Authorization
+// header; bare tokens are still accepted.
+func bearerToken(header string) string {
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return header
+}
+
+// JWTAuthMiddleware authenticates the request with either a JWT access token
+// or an API key and stores the user in the context.
 func JWTAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
+		if apiKey := apiKeyFromRequest(c); apiKey != "" {
+			authenticateAPIKey(c, db, apiKey)
+			return
+		}
+
+		tokenString := bearerToken(c.GetHeader("Authorization"))
 		if tokenString == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(JWTSecret), nil
-		})
+		token, err := jwt.Parse(tokenString, Keys().Keyfunc)
 
 		if err != nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -163,7 +234,18 @@ func JWTAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 				return
 			}
+			if typ, _ := claims["typ"].(string); typ != "" && typ != "access" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return
+			}
+			if isRevoked(db, user, claims) {
+				recordAuthEvent(db, c, models.AuthEvent{Event: models.EventTokenRejected, UserID: &user.ID, Username: user.Username, Detail: "revoked"})
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+				return
+			}
+			touchSession(db, claims)
 			c.Set("user", user)
+			c.Set("claims", claims)
 			c.Next()
 		} else {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
@@ -172,22 +254,18 @@ func JWTAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 }
 
 func GetUserIDFromToken(c *gin.Context) (uint, error) {
+	if user, exists := c.Get("user"); exists {
+		return user.(models.User).ID, nil
+	}
+
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
 		return 0, fmt.Errorf("authorization header is missing")
 	}
 
-	tokenString := authHeader
+	tokenString := bearerToken(authHeader)
 
-	// Replace with your actual JWT secret key
-	secretKey := []byte("your-secret-key")
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+	token, err := jwt.Parse(tokenString, Keys().Keyfunc)
 
 	if err != nil {
 		return 0, fmt.Errorf("invalid token: %w", err)
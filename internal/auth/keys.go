@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"movie-api/internal/config"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is one entry of the key set. Retired keys keep only their
+// verification half so tokens they signed stay valid until they expire.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeyManager holds the active signing key and every key still accepted for
+// verification, addressed by the `kid` token header.
+type KeyManager struct {
+	mu      sync.RWMutex
+	keys    map[string]*SigningKey
+	current string
+	source  string
+}
+
+type keyFile struct {
+	Current string         `json:"current"`
+	Keys    []keyFileEntry `json:"keys"`
+}
+
+type keyFileEntry struct {
+	KID            string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKey     string `json:"private_key,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKey      string `json:"public_key,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+}
+
+var (
+	keysMu      sync.Mutex
+	defaultKeys *KeyManager
+)
+
+// SetKeyManager installs the key set used by the handlers and middleware.
+func SetKeyManager(km *KeyManager) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	defaultKeys = km
+}
+
+// Keys returns the installed key set. When none was configured a random
+// HS256 key is generated, so tokens do not survive a restart.
+func Keys() *KeyManager {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if defaultKeys == nil {
+		km, err := NewEphemeralKeyManager()
+		if err != nil {
+			panic("failed to generate signing key")
+		}
+		log.Println("auth: no JWT keys configured, using an ephemeral signing key")
+		defaultKeys = km
+	}
+	return defaultKeys
+}
+
+func NewKeyManager(keys []*SigningKey, current string) (*KeyManager, error) {
+	km := &KeyManager{}
+	if err := km.install(keys, current); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+func NewEphemeralKeyManager() (*KeyManager, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewKeyManager([]*SigningKey{NewHMACKey("ephemeral", secret)}, "ephemeral")
+}
+
+// NewKeyManagerFromConfig loads the key file when one is configured, falls
+// back to a single HS256 secret, and otherwise generates an ephemeral key.
+func NewKeyManagerFromConfig(cfg config.JWTConfig) (*KeyManager, error) {
+	if cfg.KeysFile != "" {
+		return LoadKeyFile(cfg.KeysFile)
+	}
+	if cfg.Secret != "" {
+		return NewKeyManager([]*SigningKey{NewHMACKey(cfg.KeyID, []byte(cfg.Secret))}, cfg.KeyID)
+	}
+	return NewEphemeralKeyManager()
+}
+
+func LoadKeyFile(path string) (*KeyManager, error) {
+	km := &KeyManager{source: path}
+	if err := km.Reload(); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Algorithm: AlgHS256, signKey: secret, verifyKey: secret}
+}
+
+func NewRSAKey(id string, key *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{ID: id, Algorithm: AlgRS256, signKey: key, verifyKey: &key.PublicKey}
+}
+
+func NewEdDSAKey(id string, key ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{ID: id, Algorithm: AlgEdDSA, signKey: key, verifyKey: key.Public()}
+}
+
+// Reload re-reads the key file the manager was loaded from. Tokens signed by
+// keys that are still listed keep working, which is what makes rotation
+// possible without logging users out.
+func (km *KeyManager) Reload() error {
+	if km.source == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(km.source)
+	if err != nil {
+		return fmt.Errorf("read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("parse key file: %w", err)
+	}
+
+	keys := make([]*SigningKey, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		key, err := entry.load()
+		if err != nil {
+			return fmt.Errorf("key %q: %w", entry.KID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return km.install(keys, file.Current)
+}
+
+func (km *KeyManager) install(keys []*SigningKey, current string) error {
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return fmt.Errorf("key without kid")
+		}
+		if key.method() == nil {
+			return fmt.Errorf("key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+		}
+		if _, dup := byID[key.ID]; dup {
+			return fmt.Errorf("duplicate kid %q", key.ID)
+		}
+		byID[key.ID] = key
+	}
+
+	active, ok := byID[current]
+	if !ok {
+		return fmt.Errorf("current key %q not found", current)
+	}
+	if active.signKey == nil {
+		return fmt.Errorf("current key %q has no private key", current)
+	}
+
+	km.mu.Lock()
+	km.keys = byID
+	km.current = current
+	km.mu.Unlock()
+	return nil
+}
+
+func (e keyFileEntry) load() (*SigningKey, error) {
+	key := &SigningKey{ID: e.KID, Algorithm: e.Alg}
+
+	switch e.Alg {
+	case AlgHS256:
+		if e.Secret == "" {
+			return nil, fmt.Errorf("missing secret")
+		}
+		key.signKey = []byte(e.Secret)
+		key.verifyKey = []byte(e.Secret)
+		return key, nil
+	case AlgRS256, AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", e.Alg)
+	}
+
+	privatePEM, err := pemSource(e.PrivateKey, e.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := pemSource(e.PublicKey, e.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case privatePEM != nil && e.Alg == AlgRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey, key.verifyKey = private, &private.PublicKey
+	case privatePEM != nil:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 private key")
+		}
+		key.signKey, key.verifyKey = edKey, edKey.Public()
+	case publicPEM != nil && e.Alg == AlgRS256:
+		if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM); err != nil {
+			return nil, err
+		}
+	case publicPEM != nil:
+		if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(publicPEM); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("missing private or public key")
+	}
+
+	return key, nil
+}
+
+func pemSource(inline, path string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+// Sign signs the claims with the current key and records its kid in the
+// token header.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	km.mu.RLock()
+	key := km.keys[km.current]
+	km.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc resolves the verification key for a parsed token. The algorithm
+// in the header must match the key's, so an RS256 public key can never be
+// used as an HMAC secret.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = km.current
+	}
+
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+func (km *KeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, km.Keyfunc)
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public halves of the asymmetric keys. HMAC secrets are
+// never published.
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+// JWKSHandler godoc
+// @Summary JSON Web Key Set
+// @Description Public keys used to verify access tokens
+// @Tags authentication
+// @Produce json
+// @Success 200 {object} JWKSet
+// @Router /.well-known/jwks.json [get]
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, Keys().JWKS())
+	}
+}
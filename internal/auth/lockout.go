@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"math"
+	"movie-api/internal/config"
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	loginPolicyMu sync.RWMutex
+	loginPolicy   = config.DefaultLoginConfig()
+)
+
+// SetLoginPolicy replaces the brute-force protection thresholds.
+func SetLoginPolicy(policy config.LoginConfig) {
+	loginPolicyMu.Lock()
+	defer loginPolicyMu.Unlock()
+	loginPolicy = policy
+}
+
+func currentLoginPolicy() config.LoginConfig {
+	loginPolicyMu.RLock()
+	defer loginPolicyMu.RUnlock()
+	return loginPolicy
+}
+
+type throttleKey struct {
+	key       string
+	free      int
+	threshold int
+}
+
+func throttleKeys(policy config.LoginConfig, username, ip string) []throttleKey {
+	keys := []throttleKey{{"user:" + username, policy.UserFreeAttempts, policy.UserLockoutThreshold}}
+	if ip != "" {
+		keys = append(keys, throttleKey{"ip:" + ip, policy.IPFreeAttempts, policy.IPLockoutThreshold})
+	}
+	return keys
+}
+
+// lockDelay grows exponentially from one second once the free attempts are
+// used up, and becomes the full lockout at the threshold.
+func lockDelay(policy config.LoginConfig, key throttleKey, failures int) time.Duration {
+	if failures >= key.threshold {
+		return policy.LockoutDuration
+	}
+	if failures < key.free {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-key.free))) * time.Second
+	if delay > policy.LockoutDuration {
+		return policy.LockoutDuration
+	}
+	return delay
+}
+
+// loginRetryAfter returns how long the username or address must wait before
+// the next attempt, or zero when a login may be tried now.
+func loginRetryAfter(db *gorm.DB, username, ip string) time.Duration {
+	keys := []string{}
+	for _, key := range throttleKeys(currentLoginPolicy(), username, ip) {
+		keys = append(keys, key.key)
+	}
+
+	now := time.Now()
+	var locked []models.LoginThrottle
+	db.Where("throttle_key IN ? AND locked_until > ?", keys, now).Find(&locked)
+
+	var wait time.Duration
+	for _, row := range locked {
+		if remaining := row.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed attempt against the username and the
+// address. The counter is bumped with a single upsert so concurrent API
+// processes never lose an increment. Counters older than the lockout
+// window start over.
+func recordLoginFailure(db *gorm.DB, username, ip string) time.Duration {
+	policy := currentLoginPolicy()
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range throttleKeys(policy, username, ip) {
+		var failures int
+		err := db.Raw(`INSERT INTO login_throttles (throttle_key, failures, last_failure_at) VALUES (?, 1, ?)
+			ON CONFLICT(throttle_key) DO UPDATE SET
+				failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+				last_failure_at = excluded.last_failure_at
+			RETURNING failures`, key.key, now, now.Add(-policy.LockoutDuration)).Scan(&failures).Error
+		if err != nil {
+			continue
+		}
+
+		delay := lockDelay(policy, key, failures)
+		if delay == 0 {
+			continue
+		}
+
+		until := now.Add(delay)
+		db.Model(&models.LoginThrottle{}).
+			Where("throttle_key = ? AND (locked_until IS NULL OR locked_until < ?)", key.key, until).
+			Update("locked_until", until)
+
+		if failures >= key.threshold {
+			db.Create(&models.LockoutEvent{
+				Key:         key.key,
+				Username:    username,
+				IP:          ip,
+				Failures:    failures,
+				LockedUntil: until,
+			})
+		}
+
+		if delay > wait {
+			wait = delay
+		}
+	}
+	return wait
+}
+
+func clearLoginFailures(db *gorm.DB, username string) {
+	db.Where("throttle_key = ?", "user:"+username).Delete(&models.LoginThrottle{})
+}
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// ListLockouts godoc
+// @Summary List lockout events
+// @Description List login lockouts, newest first. Pass active=true to only see lockouts that are still in force.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param active query bool false "Only active lockouts"
+// @Success 200 {array} models.LockoutEvent
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/lockouts [get]
+func ListLockouts(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Order("id DESC").Limit(100)
+		if c.Query("active") == "true" {
+			query = query.Where("locked_until > ? AND unlocked_at IS NULL", time.Now())
+		}
+
+		var events []models.LockoutEvent
+		if err := query.Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lockouts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// UnlockLogin godoc
+// @Summary Unlock login
+// @Description Clear failed login counters and lockouts for a username and/or an IP address
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.UnlockRequest true "Username and/or IP to unlock"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/unlock [post]
+func UnlockLogin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.UnlockRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Username == "" && req.IP == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username or ip is required"})
+			return
+		}
+
+		keys := []string{}
+		if req.Username != "" {
+			keys = append(keys, "user:"+req.Username)
+		}
+		if req.IP != "" {
+			keys = append(keys, "ip:"+req.IP)
+		}
+
+		admin := c.MustGet("user").(models.User)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("throttle_key IN ?", keys).Delete(&models.LoginThrottle{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.LockoutEvent{}).
+				Where("throttle_key IN ? AND unlocked_at IS NULL", keys).
+				Updates(map[string]interface{}{"unlocked_at": time.Now(), "unlocked_by": admin.ID}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "unlocked"})
+	}
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"crypto/rand"
+	"movie-api/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+func issueMFAToken(userID uint) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return Keys().Sign(jwt.MapClaims{
+		"sub": userID,
+		"typ": "mfa",
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(mfaTokenTTL).Unix(),
+	})
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// newRecoveryCodes replaces every recovery code of the user and returns the
+// new codes in readable xxxxx-xxxxx form.
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := base32NoPadding.EncodeToString(raw)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: hashToken(code)})
+	}
+
+	return codes, tx.Create(&rows).Error
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code.
+// Both are consumed with a conditional update so the same code cannot be
+// accepted twice, even by concurrent requests.
+func checkSecondFactor(db *gorm.DB, user models.User, code string) bool {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totpDigits && user.TOTPSecret != nil {
+		step, ok := verifyTOTP(*user.TOTPSecret, code, user.TOTPLastStep, time.Now())
+		if !ok {
+			return false
+		}
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// StartTOTPEnrollment godoc
+// @Summary Start TOTP enrollment
+// @Description Generate a TOTP secret for the current user. The otpauth URI is the payload for the QR code shown to the user.
+// @Tags mfa
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.TOTPEnrollmentResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/mfa/totp [post]
+func StartTOTPEnrollment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		if user.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrollment"})
+			return
+		}
+
+		if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrollment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.TOTPEnrollmentResponse{
+			Secret:     secret,
+			OTPAuthURI: totpURI(user.Username, secret),
+		})
+	}
+}
+
+// ConfirmTOTPEnrollment godoc
+// @Summary Confirm TOTP enrollment
+// @Description Enable two-factor authentication with a code from the authenticator. Returns the recovery codes, which are only shown once.
+// @Tags mfa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "TOTP code"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/mfa/totp/verify [post]
+func ConfirmTOTPEnrollment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		if user.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		if user.TOTPSecret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "enrollment has not been started"})
+			return
+		}
+
+		step, ok := verifyTOTP(*user.TOTPSecret, strings.TrimSpace(req.Code), user.TOTPLastStep, time.Now())
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		var codes []string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+				return err
+			}
+			var err error
+			codes, err = newRecoveryCodes(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTOTP godoc
+// @Summary Disable TOTP
+// @Description Turn off two-factor authentication. Requires a TOTP or recovery code.
+// @Tags mfa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/mfa/totp [delete]
+func DisableTOTP(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+		if !checkSecondFactor(db, user, req.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"totp_secret":    nil,
+				"totp_enabled":   false,
+				"totp_last_step": 0,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes. Requires a TOTP or recovery code.
+// @Tags mfa
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+		if !checkSecondFactor(db, user, req.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		codes, err := newRecoveryCodes(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// MFALoginHandler godoc
+// @Summary Complete two-factor login
+// @Description Exchange the MFA token from /token and a TOTP or recovery code for API tokens
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "MFA token and code"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Router /token/mfa [post]
+func MFALoginHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MFALoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := Keys().Parse(req.MFAToken, claims)
+		if err != nil || !token.Valid || claims["typ"] != "mfa" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA token"})
+			return
+		}
+
+		sub, _ := claims["sub"].(float64)
+		var user models.User
+		if err := db.First(&user, uint(sub)).Error; err != nil || !user.TOTPEnabled || isRevoked(db, user, claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA token"})
+			return
+		}
+
+		ip := c.ClientIP()
+		if wait := loginRetryAfter(db, user.Username, ip); wait > 0 {
+			setRetryAfter(c, wait)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts"})
+			return
+		}
+
+		if !checkSecondFactor(db, user, req.Code) {
+			recordAuthEvent(db, c, models.AuthEvent{Event: models.EventLoginFailure, UserID: &user.ID, Username: user.Username, Detail: "second factor"})
+			if wait := recordLoginFailure(db, user.Username, ip); wait > 0 {
+				setRetryAfter(c, wait)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+		clearLoginFailures(db, user.Username)
+
+		// The challenge is single use.
+		revokeAccessToken(db, claims)
+		recordAuthEvent(db, c, models.AuthEvent{Event: models.EventLoginSuccess, UserID: &user.ID, Username: user.Username, Detail: "second factor"})
+
+		tokens, err := issueTokenPair(db, c, user, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
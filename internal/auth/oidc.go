@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"movie-api/internal/config"
+	"movie-api/internal/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// oidcKeyRefreshInterval stops an unknown kid from making us fetch the
+	// provider's JWKS on every request.
+	oidcKeyRefreshInterval = time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider talks to an OpenID Connect identity provider. The discovery
+// document and signing keys are fetched on first use and cached.
+type OIDCProvider struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *OIDCProvider) getJSON(endpoint string, v interface{}) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc oidcDiscovery
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(endpoint, &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if doc.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, p.cfg.Issuer)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) verificationKey(kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > oidcKeyRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	var set JWKSet
+	if err := p.getJSON(doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if public, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = public
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k JWK) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func (p *OIDCProvider) authorizationURL(state, nonce, verifier string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// exchange redeems the authorization code and returns the raw ID token.
+func (p *OIDCProvider) exchange(code, verifier string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	resp, err := p.client.PostForm(doc.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+func (p *OIDCProvider) verifyIDToken(raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("unexpected audience")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("missing sub claim")
+	}
+	return claims, nil
+}
+
+// linkOIDCUser finds the account bound to the issuer and subject. An
+// unlinked account is only adopted when both the provider and the local
+// account have verified its email address; otherwise a new account is
+// created, so nobody can register someone else's address ahead of their
+// first SSO login and share their account.
+func linkOIDCUser(db *gorm.DB, issuer string, claims jwt.MapClaims) (models.User, error) {
+	sub := claims["sub"].(string)
+
+	var user models.User
+	if err := db.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, sub).First(&user).Error; err == nil {
+		return user, nil
+	}
+
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	if email != "" && verified {
+		err := db.Where("email = ? AND oidc_subject IS NULL AND email_verified_at IS NOT NULL", email).First(&user).Error
+		if err == nil {
+			err := db.Model(&user).Updates(map[string]interface{}{
+				"oidc_issuer":  issuer,
+				"oidc_subject": sub,
+			}).Error
+			return user, err
+		}
+	}
+
+	username, err := uniqueUsername(db, oidcUsername(claims))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user = models.User{Username: username, OIDCIssuer: &issuer, OIDCSubject: &sub}
+	if email != "" && verified {
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		if count == 0 {
+			now := time.Now()
+			user.Email = &email
+			user.EmailVerifiedAt = &now
+		}
+	}
+	return user, db.Create(&user).Error
+}
+
+func oidcUsername(claims jwt.MapClaims) string {
+	name, _ := claims["preferred_username"].(string)
+	if name == "" {
+		email, _ := claims["email"].(string)
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if len(name) < 3 {
+		name = "user"
+	}
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return name
+}
+
+func uniqueUsername(db *gorm.DB, base string) (string, error) {
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var count int64
+		db.Unscoped().Model(&models.User{}).Where("username = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate, nil
+		}
+
+		suffix, err := randomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + suffix
+	}
+	return "", fmt.Errorf("could not find a free username for %q", base)
+}
+
+// OIDCLogin godoc
+// @Summary Start single sign-on
+// @Description Redirect to the OpenID Connect provider (authorization code flow with PKCE)
+// @Tags authentication
+// @Success 302
+// @Failure 502 {object} models.ErrorResponse
+// @Router /oidc/login [get]
+func OIDCLogin(db *gorm.DB, provider *OIDCProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, err1 := randomToken(16)
+		nonce, err2 := randomToken(16)
+		verifier, err3 := randomToken(32)
+		if err1 != nil || err2 != nil || err3 != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+			return
+		}
+
+		redirect, err := provider.authorizationURL(state, nonce, verifier)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+			return
+		}
+
+		pending := models.OIDCLoginState{
+			State:        state,
+			CodeVerifier: verifier,
+			Nonce:        nonce,
+			ExpiresAt:    time.Now().Add(oidcStateTTL),
+		}
+		if err := db.Create(&pending).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+			return
+		}
+
+		c.Redirect(http.StatusFound, redirect)
+	}
+}
+
+// OIDCCallback godoc
+// @Summary Finish single sign-on
+// @Description Redeem the authorization code, then create or link the user and return API tokens
+// @Tags authentication
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State from the login redirect"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /oidc/callback [get]
+func OIDCCallback(db *gorm.DB, provider *OIDCProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if errCode := c.Query("error"); errCode != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed: " + errCode})
+			return
+		}
+
+		state, code := c.Query("state"), c.Query("code")
+		if state == "" || code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+			return
+		}
+
+		var pending models.OIDCLoginState
+		if err := db.Where("state = ?", state).First(&pending).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired state"})
+			return
+		}
+		// Deleting the state is what claims it, so a callback can only be replayed once.
+		result := db.Where("state = ? AND expires_at > ?", state, time.Now()).Delete(&models.OIDCLoginState{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired state"})
+			return
+		}
+
+		idToken, err := provider.exchange(code, pending.CodeVerifier)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to redeem authorization code"})
+			return
+		}
+
+		claims, err := provider.verifyIDToken(idToken, pending.Nonce)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid ID token"})
+			return
+		}
+
+		user, err := linkOIDCUser(db, provider.cfg.Issuer, claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+			return
+		}
+
+		finishLogin(db, c, user, "oidc "+provider.cfg.Issuer)
+	}
+}
+
+// PruneExpiredOIDCStates removes logins that were started but never finished.
+func PruneExpiredOIDCStates(db *gorm.DB) error {
+	return db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{}).Error
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"movie-api/internal/mail"
+	"movie-api/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = time.Hour
+
+// revokeAllSessions ends every refresh token family of the user and
+// invalidates the access tokens issued so far.
+func revokeAllSessions(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).Update("tokens_revoked_at", now).Error
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Change the password of the current user. Other sessions are signed out and a new token pair is returned.
+// @Tags users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/password [post]
+func ChangePassword(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		if ok, _ := verifyPassword(user.Password, req.CurrentPassword); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+		if !checkNewPassword(c, user.Username, req.NewPassword) {
+			return
+		}
+
+		hashed, err := hashPassword(req.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
+			return
+		}
+
+		var tokens models.TokenResponse
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("password", hashed).Error; err != nil {
+				return err
+			}
+			if err := revokeAllSessions(tx, user.ID); err != nil {
+				return err
+			}
+			tokens, err = issueTokenPair(tx, c, user, "")
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// RequestPasswordReset godoc
+// @Summary Request password reset
+// @Description Email a single-use reset token to the account with this address. The response is the same whether or not the address is known.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.PasswordResetRequest true "Account email"
+// @Success 202 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /password-reset [post]
+func RequestPasswordReset(db *gorm.DB, mailer mail.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.PasswordResetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		accepted := gin.H{"message": "if the address belongs to an account, a reset email has been sent"}
+
+		var user models.User
+		if err := db.Where("email = ? AND service_account = ?", req.Email, false).First(&user).Error; err != nil {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		token, err := randomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create reset token"})
+			return
+		}
+
+		reset := models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		}
+		if err := db.Create(&reset).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create reset token"})
+			return
+		}
+
+		err = mailer.Send(mail.Message{
+			To:      req.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hello %s,\n\nUse this token to reset your password:\n\n%s\n\n"+
+				"It expires in %d minutes. If you did not ask for a reset, ignore this email.\n",
+				user.Username, token, int(passwordResetTTL.Minutes())),
+		})
+		// A failure is only logged: answering differently than for unknown
+		// addresses would tell which ones have accounts.
+		if err != nil {
+			log.Printf("failed to send password reset email: %v", err)
+			recordAuthEvent(db, c, models.AuthEvent{
+				Event:    models.EventResetMailFailed,
+				UserID:   &user.ID,
+				Username: user.Username,
+				Detail:   err.Error(),
+			})
+		}
+
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// ConfirmPasswordReset godoc
+// @Summary Confirm password reset
+// @Description Set a new password with a reset token. The token can only be used once and every session of the user is signed out.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.PasswordResetConfirmRequest true "Reset token and new password"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /password-reset/confirm [post]
+func ConfirmPasswordReset(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.PasswordResetConfirmRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		errInvalidToken := fmt.Errorf("invalid or expired reset token")
+
+		// The policy needs the username; the token itself is checked and
+		// consumed below.
+		var owner models.User
+		if err := db.Joins("JOIN password_reset_tokens ON password_reset_tokens.user_id = users.id").
+			Where("password_reset_tokens.token_hash = ?", hashToken(req.Token)).
+			First(&owner).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidToken.Error()})
+			return
+		}
+		if !checkNewPassword(c, owner.Username, req.NewPassword) {
+			return
+		}
+
+		hashed, err := hashPassword(req.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var reset models.PasswordResetToken
+			if err := tx.Where("token_hash = ?", hashToken(req.Token)).First(&reset).Error; err != nil {
+				return errInvalidToken
+			}
+
+			// Consuming the token and checking it is unused happen in one
+			// statement so two concurrent requests cannot both succeed.
+			now := time.Now()
+			result := tx.Model(&models.PasswordResetToken{}).
+				Where("id = ? AND used_at IS NULL AND expires_at > ?", reset.ID, now).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInvalidToken
+			}
+
+			if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", hashed).Error; err != nil {
+				return err
+			}
+			return revokeAllSessions(tx, reset.UserID)
+		})
+		if err == errInvalidToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "password updated"})
+	}
+}
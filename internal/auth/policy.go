@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"movie-api/internal/breach"
+	"movie-api/internal/config"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BreachedPasswords is a list of passwords known from data breaches.
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// PasswordPolicy holds the rules every new password must follow.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	MinCharClasses int
+	RejectUsername bool
+	Breached       []BreachedPasswords
+}
+
+// PasswordPolicyError lists every rule a password broke.
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+var (
+	passwordPolicyMu sync.RWMutex
+	passwordPolicy   = NewPasswordPolicy(config.DefaultPasswordConfig())
+)
+
+// NewPasswordPolicy builds the policy from the configuration. The built-in
+// list of common passwords is always screened in addition to lists.
+func NewPasswordPolicy(cfg config.PasswordConfig, lists ...BreachedPasswords) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      cfg.MinLength,
+		MaxLength:      cfg.MaxLength,
+		MinCharClasses: cfg.MinCharClasses,
+		RejectUsername: cfg.RejectUsername,
+		Breached:       append([]BreachedPasswords{breach.Common()}, lists...),
+	}
+}
+
+// SetPasswordPolicy replaces the rules for new passwords.
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicyMu.Lock()
+	defer passwordPolicyMu.Unlock()
+	passwordPolicy = policy
+}
+
+func currentPasswordPolicy() PasswordPolicy {
+	passwordPolicyMu.RLock()
+	defer passwordPolicyMu.RUnlock()
+	return passwordPolicy
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// Check returns a *PasswordPolicyError when the password breaks a rule. The
+// breached lists are only consulted for passwords that pass the others.
+func (p PasswordPolicy) Check(username, password string) error {
+	var problems []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("password must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("password must be at most %d characters", p.MaxLength))
+	}
+	if charClasses(password) < p.MinCharClasses {
+		problems = append(problems, fmt.Sprintf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses))
+	}
+	if p.RejectUsername && len(username) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problems = append(problems, "password must not contain the username")
+	}
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+
+	for _, list := range p.Breached {
+		found, err := list.Contains(password)
+		if err != nil {
+			return fmt.Errorf("check breached passwords: %w", err)
+		}
+		if found {
+			return &PasswordPolicyError{Problems: []string{"password appears in a list of breached passwords"}}
+		}
+	}
+	return nil
+}
+
+// checkNewPassword applies the password policy and, when the password is
+// refused, writes the error response.
+func checkNewPassword(c *gin.Context, username, password string) bool {
+	err := currentPasswordPolicy().Check(username, password)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": policyErr.Error()})
+		return false
+	}
+
+	log.Printf("auth: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check password"})
+	return false
+}
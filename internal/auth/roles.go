@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RequireRole lets the request through when the authenticated user holds one
+// of the given roles. It must run after JWTAuthMiddleware.
+func RequireRole(roles ...models.UserRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		user := value.(models.User)
+		if user.Role == models.RoleAdmin {
+			c.Next()
+			return
+		}
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+	}
+}
+
+// EnsureAdmin promotes the named user to admin. It is used at startup to
+// bootstrap the first administrator.
+func EnsureAdmin(db *gorm.DB, username string) error {
+	return db.Model(&models.User{}).
+		Where("username = ?", username).
+		Update("role", models.RoleAdmin).Error
+}
+
+// GrantRole godoc
+// @Summary Grant a role
+// @Description Set the role of a user
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body models.RoleRequest true "Role to grant"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Router /admin/users/{id}/role [put]
+func GrantRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.RoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !req.Role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+			return
+		}
+
+		setRole(c, db, req.Role)
+	}
+}
+
+// RevokeRole godoc
+// @Summary Revoke a role
+// @Description Reset a user back to the default user role
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Router /admin/users/{id}/role [delete]
+func RevokeRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setRole(c, db, models.RoleUser)
+	}
+}
+
+func setRole(c *gin.Context, db *gorm.DB, role models.UserRole) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+		var admins int64
+		db.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&admins)
+		if admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot remove the last admin"})
+			return
+		}
+	}
+
+	if err := db.Model(&user).Update("role", role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+		return
+	}
+	user.Role = role
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	})
+}
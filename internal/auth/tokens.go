@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"movie-api/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func issueToken(userID, sessionID uint) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return Keys().Sign(jwt.MapClaims{
+		"sub": userID,
+		"sid": sessionID,
+		"typ": "access",
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(accessTokenTTL).Unix(),
+	})
+}
+
+// issueTokenPair creates an access token and a refresh token. An empty
+// familyID starts a new session; refreshes pass the family along. The
+// session records the client the tokens were issued to.
+func issueTokenPair(db *gorm.DB, c *gin.Context, user models.User, familyID string) (models.TokenResponse, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(refreshTokenTTL)
+	event := models.EventTokenRefreshed
+	if familyID == "" {
+		if familyID, err = randomToken(16); err != nil {
+			return models.TokenResponse{}, err
+		}
+		event = models.EventTokenIssued
+	}
+
+	var session models.Session
+	if err := db.Where(models.Session{FamilyID: familyID}).
+		Attrs(models.Session{UserID: user.ID}).
+		FirstOrCreate(&session).Error; err != nil {
+		return models.TokenResponse{}, err
+	}
+	if err := db.Model(&session).Updates(map[string]interface{}{
+		"ip":           c.ClientIP(),
+		"user_agent":   clientUserAgent(c),
+		"last_seen_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	accessToken, err := issueToken(user.ID, session.ID)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	recordAuthEvent(db, c, models.AuthEvent{
+		Event:     event,
+		UserID:    &user.ID,
+		Username:  user.Username,
+		SessionID: &session.ID,
+	})
+
+	return models.TokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func revokeFamily(db *gorm.DB, familyID string) error {
+	now := time.Now()
+	if err := db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// rejectReusedToken ends the session of a refresh token that was used
+// after it had been rotated.
+func rejectReusedToken(db *gorm.DB, c *gin.Context, stored models.RefreshToken) {
+	if err := revokeFamily(db, stored.FamilyID); err != nil {
+		log.Printf("auth: failed to revoke token family %s: %v", stored.FamilyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+	recordAuthEvent(db, c, models.AuthEvent{Event: models.EventRefreshReuse, UserID: &stored.UserID})
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+}
+
+func revokeAccessToken(db *gorm.DB, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" {
+		return nil
+	}
+	return db.Save(&models.RevokedToken{JTI: jti, ExpiresAt: time.Unix(int64(exp), 0)}).Error
+}
+
+// isRevoked reports whether the access token was revoked on its own, with
+// its session, or by a sign-out of every session that happened after it was
+// issued.
+func isRevoked(db *gorm.DB, user models.User, claims jwt.MapClaims) bool {
+	if user.TokensRevokedAt != nil {
+		iat, _ := claims["iat"].(float64)
+		if int64(iat) < user.TokensRevokedAt.Unix() {
+			return true
+		}
+	}
+
+	if sid, ok := sessionID(claims); ok {
+		var active int64
+		db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sid).Count(&active)
+		if active == 0 {
+			return true
+		}
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return false
+	}
+	var count int64
+	db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	return count > 0
+}
+
+// PruneExpiredTokens removes revocation entries, refresh tokens and sessions
+// that have expired and can no longer be presented.
+func PruneExpiredTokens(db *gorm.DB) error {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := db.Unscoped().Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("expires_at < ?", now).Delete(&models.Session{}).Error
+}
+
+// RefreshHandler godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token and a rotated refresh token
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshRequest true "Refresh token"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /token/refresh [post]
+func RefreshHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		var stored models.RefreshToken
+		if err := db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&stored).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+
+		if stored.RevokedAt != nil {
+			// A rotated token came back: assume it was stolen and end the session.
+			rejectReusedToken(db, c, stored)
+			return
+		}
+
+		if time.Now().After(stored.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+			return
+		}
+
+		result := db.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			rejectReusedToken(db, c, stored)
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, stored.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+
+		tokens, err := issueTokenPair(db, c, user, stored.FamilyID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// LogoutHandler godoc
+// @Summary Log out
+// @Description Revoke the current access token and the given refresh token or, without one, the current session. With all=true every session of the user is ended.
+// @Tags authentication
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.LogoutRequest false "Sessions to end"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /logout [post]
+func LogoutHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.LogoutRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		claims := c.MustGet("claims").(jwt.MapClaims)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := revokeAccessToken(tx, claims); err != nil {
+				return err
+			}
+
+			if req.All {
+				return revokeAllSessions(tx, user.ID)
+			}
+
+			if req.RefreshToken != "" {
+				var stored models.RefreshToken
+				err := tx.Where("token_hash = ? AND user_id = ?", hashToken(req.RefreshToken), user.ID).
+					First(&stored).Error
+				if err == nil {
+					return revokeFamily(tx, stored.FamilyID)
+				}
+				return nil
+			}
+
+			// Without a refresh token, end the session of the access token.
+			if sid, ok := sessionID(claims); ok {
+				var session models.Session
+				if tx.Where("id = ? AND user_id = ?", sid, user.ID).First(&session).Error == nil {
+					return revokeSession(tx, session)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		event := models.AuthEvent{Event: models.EventLogout, UserID: &user.ID, Username: user.Username}
+		if req.All {
+			event.Event = models.EventSessionsRevoked
+		}
+		if sid, ok := sessionID(claims); ok {
+			event.SessionID = &sid
+		}
+		recordAuthEvent(db, c, event)
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+	}
+}
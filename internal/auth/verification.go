@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"fmt"
+	"movie-api/internal/mail"
+	"movie-api/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	verificationTokenTTL = 24 * time.Hour
+	// verificationResendInterval is the minimum time between two
+	// verification emails to the same account.
+	verificationResendInterval = time.Minute
+)
+
+// emailVerified reports whether the account may write. Service accounts
+// have no email and admins are trusted; curators and moderators verify
+// like everyone else, since they can change the catalog.
+func emailVerified(user models.User) bool {
+	return user.EmailVerifiedAt != nil || user.ServiceAccount || user.Role == models.RoleAdmin
+}
+
+// SendEmailVerification mails a new verification token for the user's
+// current address.
+func SendEmailVerification(db *gorm.DB, mailer mail.Mailer, user models.User) error {
+	if user.Email == nil {
+		return fmt.Errorf("user %d has no email address", user.ID)
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	verification := models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     *user.Email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(verificationTokenTTL),
+	}
+	if err := db.Create(&verification).Error; err != nil {
+		return err
+	}
+
+	return mailer.Send(mail.Message{
+		To:      *user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this token to verify your email address:\n\n%s\n\n"+
+			"It expires in %d hours. Until then you can browse but not post reviews.\n",
+			user.Username, token, int(verificationTokenTTL.Hours())),
+	})
+}
+
+// RequireVerifiedEmail refuses write access to accounts that have not
+// verified their email address. Use it after JWTAuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		if !emailVerified(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// VerifyEmail godoc
+// @Summary Verify email address
+// @Description Confirm the email address of an account with the token from the verification email
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyEmailRequest true "Verification token"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/verify-email [post]
+func VerifyEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		errInvalidToken := fmt.Errorf("invalid or expired verification token")
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var verification models.EmailVerificationToken
+			if err := tx.Where("token_hash = ?", hashToken(req.Token)).First(&verification).Error; err != nil {
+				return errInvalidToken
+			}
+
+			now := time.Now()
+			result := tx.Model(&models.EmailVerificationToken{}).
+				Where("id = ? AND used_at IS NULL AND expires_at > ?", verification.ID, now).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInvalidToken
+			}
+
+			// The token only counts for the address it was sent to.
+			result = tx.Model(&models.User{}).
+				Where("id = ? AND email = ?", verification.UserID, verification.Email).
+				Update("email_verified_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInvalidToken
+			}
+			return nil
+		})
+		if err == errInvalidToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+	}
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification token to the email address of the current user
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 202 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/verify-email [post]
+func ResendVerification(db *gorm.DB, mailer mail.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		if user.Email == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account has no email address"})
+			return
+		}
+		if user.EmailVerifiedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "email address already verified"})
+			return
+		}
+
+		var recent int64
+		db.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-verificationResendInterval)).
+			Count(&recent)
+		if recent > 0 {
+			setRetryAfter(c, verificationResendInterval)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "verification email was sent recently"})
+			return
+		}
+
+		if err := SendEmailVerification(db, mailer, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+	}
+}
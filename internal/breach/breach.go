@@ -0,0 +1,209 @@
+// Package breach screens passwords against lists of known breached
+// passwords.
+//
+// A list file is the 8 byte header "PWLIST01" followed by the sorted,
+// big-endian first 8 bytes of the SHA-1 of every password. At 8 bytes per
+// entry even lists with hundreds of millions of passwords stay manageable,
+// and lookups binary search the file on disk instead of loading it. Lists
+// are built offline with cmd/breachlist.
+package breach
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	header    = "PWLIST01"
+	entrySize = 8
+)
+
+var errFormat = errors.New("not a breached password list")
+
+// Key is the list entry of a password: the first 8 bytes of its SHA-1.
+func Key(password string) uint64 {
+	sum := sha1.Sum([]byte(password))
+	return binary.BigEndian.Uint64(sum[:entrySize])
+}
+
+// KeyFromSHA1 converts a hex SHA-1, as published in breach corpora, to a
+// list entry.
+func KeyFromSHA1(hexDigest string) (uint64, error) {
+	if len(hexDigest) != sha1.Size*2 {
+		return 0, fmt.Errorf("invalid SHA-1 %q", hexDigest)
+	}
+	raw, err := hex.DecodeString(hexDigest[:entrySize*2])
+	if err != nil {
+		return 0, fmt.Errorf("invalid SHA-1 %q", hexDigest)
+	}
+	return binary.BigEndian.Uint64(raw), nil
+}
+
+// Write stores the keys in list format, sorted and without duplicates.
+func Write(w io.Writer, keys []uint64) error {
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	out := bufio.NewWriter(w)
+	if _, err := out.WriteString(header); err != nil {
+		return err
+	}
+	var buf [entrySize]byte
+	for _, key := range keys {
+		binary.BigEndian.PutUint64(buf[:], key)
+		if _, err := out.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// List is a breached password list, either read from a file or held in
+// memory. It is safe for concurrent use.
+type List struct {
+	mu      sync.RWMutex
+	path    string
+	r       io.ReaderAt
+	closer  io.Closer
+	entries int64
+}
+
+// Open opens a list file. The file stays open and is searched in place.
+func Open(path string) (*List, error) {
+	l := &List{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewList builds an in-memory list from keys.
+func NewList(keys []uint64) *List {
+	var buf bytes.Buffer
+	Write(&buf, keys)
+	l := &List{}
+	l.install(bytes.NewReader(buf.Bytes()), nil, int64(buf.Len()))
+	return l
+}
+
+// Reload reopens the file the list was opened from, so a list updated
+// offline can be swapped in with a rename and a reload.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := l.install(f, f, info.Size()); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+	return nil
+}
+
+func (l *List) install(r io.ReaderAt, closer io.Closer, size int64) error {
+	magic := make([]byte, len(header))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != header {
+		return errFormat
+	}
+	if (size-int64(len(header)))%entrySize != 0 {
+		return errFormat
+	}
+
+	l.mu.Lock()
+	old := l.closer
+	l.r, l.closer = r, closer
+	l.entries = (size - int64(len(header))) / entrySize
+	l.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Len returns the number of passwords in the list.
+func (l *List) Len() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.entries
+}
+
+// Contains reports whether the password is on the list.
+func (l *List) Contains(password string) (bool, error) {
+	key := Key(password)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var buf [entrySize]byte
+	lo, hi := int64(0), l.entries
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := l.r.ReadAt(buf[:], int64(len(header))+mid*entrySize); err != nil {
+			return false, err
+		}
+		switch entry := binary.BigEndian.Uint64(buf[:]); {
+		case entry == key:
+			return true, nil
+		case entry < key:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (l *List) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closer == nil {
+		return nil
+	}
+	err := l.closer.Close()
+	l.closer = nil
+	return err
+}
+
+//go:embed common.txt
+var commonPasswords string
+
+var (
+	commonOnce sync.Once
+	common     *List
+)
+
+// Common returns the built-in list of the most used passwords, which is
+// always checked, with or without a list file.
+func Common() *List {
+	commonOnce.Do(func() {
+		var keys []uint64
+		for _, line := range strings.Split(commonPasswords, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, Key(line))
+			}
+		}
+		common = NewList(keys)
+	})
+	return common
+}
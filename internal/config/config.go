@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings of the API. Every field is read from the
+// environment so the same binary can be configured per deployment.
+type Config struct {
+	JWT JWTConfig
+	// AdminUsername is promoted to admin at startup so the first
+	// administrator can grant roles to everyone else.
+	AdminUsername string
+	Mail          MailConfig
+	Login         LoginConfig
+	OIDC          OIDCConfig
+	Password      PasswordConfig
+	// DeletionGracePeriod is how long a deleted account can still be
+	// restored before its data is purged.
+	DeletionGracePeriod time.Duration
+}
+
+// OIDCConfig describes the OpenID Connect provider used for single sign-on.
+// SSO is disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// LoginConfig tunes brute-force protection. After FreeAttempts failures
+// each further attempt is delayed exponentially, and at LockoutThreshold
+// the key is locked for LockoutDuration. IP limits are separate because
+// many users can share one address.
+type LoginConfig struct {
+	UserFreeAttempts     int
+	UserLockoutThreshold int
+	IPFreeAttempts       int
+	IPLockoutThreshold   int
+	LockoutDuration      time.Duration
+}
+
+// PasswordConfig selects the algorithm for new password hashes and the
+// rules new passwords must follow. Argon2 memory is in KiB. Existing hashes
+// made with other settings are upgraded when their owner logs in.
+type PasswordConfig struct {
+	Algorithm         string
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+
+	MinLength int
+	MaxLength int
+	// MinCharClasses is how many of lowercase, uppercase, digits and
+	// symbols a password must mix.
+	MinCharClasses int
+	RejectUsername bool
+	// BreachedList is a list file built with cmd/breachlist. The built-in
+	// list of common passwords is checked either way.
+	BreachedList string
+}
+
+// MailConfig selects how outgoing email is delivered. Without an SMTP host
+// messages are written to Dir instead.
+type MailConfig struct {
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	Dir          string
+}
+
+// JWTConfig describes where the token signing keys come from. KeysFile takes
+// precedence over Secret; when neither is set an ephemeral key is generated.
+type JWTConfig struct {
+	KeysFile string
+	Secret   string
+	KeyID    string
+}
+
+func DefaultLoginConfig() LoginConfig {
+	return LoginConfig{
+		UserFreeAttempts:     5,
+		UserLockoutThreshold: 10,
+		IPFreeAttempts:       20,
+		IPLockoutThreshold:   50,
+		LockoutDuration:      15 * time.Minute,
+	}
+}
+
+// DefaultPasswordConfig follows the OWASP baseline for argon2id.
+func DefaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Algorithm:         "argon2id",
+		Argon2Memory:      19 * 1024,
+		Argon2Iterations:  2,
+		Argon2Parallelism: 1,
+		BcryptCost:        10,
+		MinLength:         8,
+		MaxLength:         128,
+		MinCharClasses:    1,
+		RejectUsername:    true,
+	}
+}
+
+func Load() Config {
+	login := DefaultLoginConfig()
+	password := DefaultPasswordConfig()
+	return Config{
+		JWT: JWTConfig{
+			KeysFile: os.Getenv("JWT_KEYS_FILE"),
+			Secret:   os.Getenv("JWT_SECRET"),
+			KeyID:    getEnv("JWT_KEY_ID", "default"),
+		},
+		AdminUsername: os.Getenv("ADMIN_USERNAME"),
+		Mail: MailConfig{
+			SMTPHost:     os.Getenv("SMTP_HOST"),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: os.Getenv("SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			From:         getEnv("MAIL_FROM", "Movie API <no-reply@movieapi.com>"),
+			Dir:          getEnv("MAIL_DIR", "outbox"),
+		},
+		Login: LoginConfig{
+			UserFreeAttempts:     getEnvInt("LOGIN_USER_FREE_ATTEMPTS", login.UserFreeAttempts),
+			UserLockoutThreshold: getEnvInt("LOGIN_USER_LOCKOUT_THRESHOLD", login.UserLockoutThreshold),
+			IPFreeAttempts:       getEnvInt("LOGIN_IP_FREE_ATTEMPTS", login.IPFreeAttempts),
+			IPLockoutThreshold:   getEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", login.IPLockoutThreshold),
+			LockoutDuration:      time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", int(login.LockoutDuration.Minutes()))) * time.Minute,
+		},
+		OIDC: OIDCConfig{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8000/api/oidc/callback"),
+			Scopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid profile email")),
+		},
+		Password: PasswordConfig{
+			Algorithm:         getEnv("PASSWORD_HASH_ALGORITHM", password.Algorithm),
+			Argon2Memory:      uint32(getEnvInt("ARGON2_MEMORY_KIB", int(password.Argon2Memory))),
+			Argon2Iterations:  uint32(getEnvInt("ARGON2_ITERATIONS", int(password.Argon2Iterations))),
+			Argon2Parallelism: uint8(getEnvInt("ARGON2_PARALLELISM", int(password.Argon2Parallelism))),
+			BcryptCost:        getEnvInt("BCRYPT_COST", password.BcryptCost),
+			MinLength:         getEnvInt("PASSWORD_MIN_LENGTH", password.MinLength),
+			MaxLength:         getEnvInt("PASSWORD_MAX_LENGTH", password.MaxLength),
+			MinCharClasses:    getEnvInt("PASSWORD_MIN_CHAR_CLASSES", password.MinCharClasses),
+			RejectUsername:    getEnvBool("PASSWORD_REJECT_USERNAME", password.RejectUsername),
+			BreachedList:      os.Getenv("PASSWORD_BREACHED_LIST"),
+		},
+		DeletionGracePeriod: time.Duration(getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour,
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
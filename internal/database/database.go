@@ -22,9 +22,21 @@ func InitDB() *gorm.DB {
 		&models.Genre{},
 		&models.Person{},
 		&models.Role{},
+		&models.CrewCredit{},
 		&models.Country{},
 		&models.Language{},
 		&models.Review{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.APIKey{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.LoginThrottle{},
+		&models.LockoutEvent{},
+		&models.OIDCLoginState{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.AuthEvent{},
 	)
 	return db
 }
@@ -48,9 +60,21 @@ func InitMockDB() *gorm.DB {
         &models.Genre{},
         &models.Person{},
         &models.Role{},
+        &models.CrewCredit{},
         &models.Country{},
         &models.Language{},
         &models.Review{},
+        &models.RefreshToken{},
+        &models.RevokedToken{},
+        &models.APIKey{},
+        &models.PasswordResetToken{},
+        &models.EmailVerificationToken{},
+        &models.LoginThrottle{},
+        &models.LockoutEvent{},
+        &models.OIDCLoginState{},
+        &models.RecoveryCode{},
+        &models.Session{},
+        &models.AuthEvent{},
         &models.MovieGenre{},
         &models.MovieDirector{},
         &models.MovieWriter{},
@@ -70,4 +94,19 @@ func ClearMockDB(db *gorm.DB) {
     db.Exec("DELETE FROM movie_directors")
     db.Exec("DELETE FROM movie_writers")
     db.Exec("DELETE FROM movie_actors")
+    db.Exec("DELETE FROM roles")
+    db.Exec("DELETE FROM crew_credits")
+    db.Exec("DELETE FROM refresh_tokens")
+    db.Exec("DELETE FROM revoked_tokens")
+    db.Exec("DELETE FROM api_keys")
+    db.Exec("DELETE FROM password_reset_tokens")
+    db.Exec("DELETE FROM email_verification_tokens")
+    db.Exec("DELETE FROM login_throttles")
+    db.Exec("DELETE FROM lockout_events")
+    db.Exec("DELETE FROM oidc_login_states")
+    db.Exec("DELETE FROM recovery_codes")
+    db.Exec("DELETE FROM sessions")
+    db.Exec("DELETE FROM auth_events")
+    db.Exec("DELETE FROM movies_fts")
+    db.Exec("DELETE FROM people_fts")
 }
\ No newline at end of file
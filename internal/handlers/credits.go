@@ -0,0 +1,763 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"movie-api/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errCreditNotFound  = errors.New("Credit not found")
+	errDuplicateCredit = errors.New("the person already has this credit on the movie")
+)
+
+// CastCredit is an actor of a movie with the character played. Actors
+// added with actor_ids and no role have no credit ID, character or
+// position and are listed last.
+type CastCredit struct {
+	CreditID  uint   `json:"credit_id,omitempty"`
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Character string `json:"character"`
+	Position  int    `json:"position,omitempty"`
+}
+
+// CrewCredit is a person working on a movie in a department other than
+// directing, writing or acting.
+type CrewCredit struct {
+	CreditID   uint                  `json:"credit_id"`
+	ID         uint                  `json:"id"`
+	Name       string                `json:"name"`
+	Department models.CrewDepartment `json:"department"`
+	Job        string                `json:"job"`
+}
+
+// CastInput is one cast credit of a movie request. The billing follows
+// the order of the list.
+type CastInput struct {
+	PersonID  uint   `json:"person_id"`
+	Character string `json:"character"`
+}
+
+type addCastRequest struct {
+	PersonID  uint   `json:"person_id" binding:"required"`
+	Character string `json:"character"`
+	Position  *int   `json:"position"`
+}
+
+type updateCastRequest struct {
+	Character *string `json:"character"`
+	Position  *int    `json:"position"`
+}
+
+type reorderCastRequest struct {
+	CreditIDs []uint `json:"credit_ids" binding:"required"`
+}
+
+type addCrewRequest struct {
+	PersonID   uint                  `json:"person_id" binding:"required"`
+	Department models.CrewDepartment `json:"department" binding:"required"`
+	Job        string                `json:"job"`
+}
+
+type updateCrewRequest struct {
+	Department *models.CrewDepartment `json:"department"`
+	Job        *string                `json:"job"`
+}
+
+// loadCast returns the cast of a movie in billing order.
+func loadCast(db *gorm.DB, movieID uint) ([]CastCredit, error) {
+	cast := []CastCredit{}
+	err := db.Raw(`SELECT credit_id, id, name, character, position FROM (
+			SELECT roles.id AS credit_id, people.id AS id, people.name AS name,
+				roles.character AS character, roles.position AS position
+			FROM roles JOIN people ON people.id = roles.person_id AND people.deleted_at IS NULL
+			WHERE roles.movie_id = @id AND roles.deleted_at IS NULL
+		UNION ALL SELECT 0, people.id, people.name, '', NULL
+			FROM movie_actors JOIN people ON people.id = movie_actors.person_id AND people.deleted_at IS NULL
+			WHERE movie_actors.movie_id = @id AND NOT EXISTS (SELECT 1 FROM roles
+				WHERE roles.movie_id = @id AND roles.person_id = movie_actors.person_id AND roles.deleted_at IS NULL))
+		ORDER BY position IS NULL, position, credit_id, name`, sql.Named("id", movieID)).
+		Scan(&cast).Error
+	return cast, err
+}
+
+// loadCrew returns the crew of a movie grouped by department.
+func loadCrew(db *gorm.DB, movieID uint) ([]CrewCredit, error) {
+	crew := []CrewCredit{}
+	err := db.Model(&models.CrewCredit{}).
+		Select("crew_credits.id AS credit_id, people.id AS id, people.name AS name, crew_credits.department, crew_credits.job").
+		Joins("JOIN people ON people.id = crew_credits.person_id AND people.deleted_at IS NULL").
+		Where("crew_credits.movie_id = ?", movieID).
+		Order("crew_credits.department, crew_credits.id").
+		Scan(&crew).Error
+	return crew, err
+}
+
+// castOrder returns the roles of a movie in billing order.
+func castOrder(tx *gorm.DB, movieID uint) ([]models.Role, error) {
+	var roles []models.Role
+	err := tx.Where("movie_id = ?", movieID).Order("position, id").Find(&roles).Error
+	return roles, err
+}
+
+// renumberCast stores the order of roles as positions 1 to n.
+func renumberCast(tx *gorm.DB, roles []models.Role) error {
+	for i, role := range roles {
+		if role.Position == i+1 {
+			continue
+		}
+		if err := tx.Model(&models.Role{}).Where("id = ?", role.ID).Update("position", i+1).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addActor makes sure the person is in movie_actors, which search and the
+// actor filter read.
+func addActor(tx *gorm.DB, movieID, personID uint) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.MovieActor{MovieID: movieID, PersonID: personID}).Error
+}
+
+// pruneCast removes the roles of people who are no longer actors of the
+// movie and closes the gaps in the billing.
+func pruneCast(tx *gorm.DB, movieID uint) error {
+	err := tx.Where("movie_id = ? AND person_id NOT IN (SELECT person_id FROM movie_actors WHERE movie_id = ?)", movieID, movieID).
+		Delete(&models.Role{}).Error
+	if err != nil {
+		return err
+	}
+	roles, err := castOrder(tx, movieID)
+	if err != nil {
+		return err
+	}
+	return renumberCast(tx, roles)
+}
+
+// replaceCast replaces the roles of a movie with cast, billed in order,
+// and adds the people to its actors.
+func replaceCast(tx *gorm.DB, movieID uint, cast []CastInput) error {
+	ids := make([]uint, 0, len(cast))
+	for _, credit := range cast {
+		if err := validateCharacter(credit.Character); err != nil {
+			return err
+		}
+		ids = append(ids, credit.PersonID)
+	}
+	if len(ids) > 0 {
+		var people []models.Person
+		if err := findAllByID(tx, &people, "cast person", ids); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("movie_id = ?", movieID).Delete(&models.Role{}).Error; err != nil {
+		return err
+	}
+	for i, credit := range cast {
+		role := models.Role{MovieID: movieID, PersonID: credit.PersonID, Character: strings.TrimSpace(credit.Character), Position: i + 1}
+		if err := tx.Omit("Movie", "Actor").Create(&role).Error; err != nil {
+			return err
+		}
+		if err := addActor(tx, movieID, credit.PersonID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateCharacter(character string) error {
+	if len(strings.TrimSpace(character)) > 100 {
+		return &movieInputError{"character must be at most 100 characters"}
+	}
+	return nil
+}
+
+// findPerson fails with an input error when the person does not exist.
+func findPerson(tx *gorm.DB, id uint) error {
+	var person models.Person
+	if err := tx.First(&person, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &movieInputError{"invalid person ID"}
+		}
+		return err
+	}
+	return nil
+}
+
+// movieIDParam reads the movie ID from the path and checks that the movie
+// exists.
+func movieIDParam(db *gorm.DB, c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return 0, false
+	}
+	var movie models.Movie
+	if err := db.Select("id").First(&movie, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Movie not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movie"})
+		}
+		return 0, false
+	}
+	return movie.ID, true
+}
+
+func creditIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("creditId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credit ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// writeCastCredit responds with the credit of the cast of the movie.
+func writeCastCredit(db *gorm.DB, c *gin.Context, status int, movieID, creditID uint) {
+	cast, err := loadCast(db, movieID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cast"})
+		return
+	}
+	for _, credit := range cast {
+		if credit.CreditID == creditID {
+			c.JSON(status, credit)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": errCreditNotFound.Error()})
+}
+
+// GetCast godoc
+// @Summary Get the cast of a movie
+// @Description List the actors of a movie with their characters in billing order
+// @Tags credits
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Success 200 {array} CastCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/cast [get]
+func GetCast(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+
+		cast, err := loadCast(db, movieID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cast"})
+			return
+		}
+
+		c.JSON(http.StatusOK, cast)
+	}
+}
+
+// AddCastCredit godoc
+// @Summary Add a cast credit
+// @Description Add an actor playing a character. The credit goes at the given billing position, moving the ones after it down, or last.
+// @Tags credits
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param credit body addCastRequest true "Cast credit"
+// @Success 201 {object} CastCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/cast [post]
+func AddCastCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req addCastRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+
+		var role models.Role
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := validateCharacter(req.Character); err != nil {
+				return err
+			}
+			if err := findPerson(tx, req.PersonID); err != nil {
+				return err
+			}
+			roles, err := castOrder(tx, movieID)
+			if err != nil {
+				return err
+			}
+			position := len(roles) + 1
+			if req.Position != nil {
+				if *req.Position < 1 || *req.Position > len(roles)+1 {
+					return &movieInputError{fmt.Sprintf("position must be between 1 and %d", len(roles)+1)}
+				}
+				position = *req.Position
+			}
+
+			role = models.Role{MovieID: movieID, PersonID: req.PersonID, Character: strings.TrimSpace(req.Character), Position: position}
+			if err := tx.Omit("Movie", "Actor").Create(&role).Error; err != nil {
+				return err
+			}
+			roles = append(roles[:position-1], append([]models.Role{role}, roles[position-1:]...)...)
+			if err := renumberCast(tx, roles); err != nil {
+				return err
+			}
+			return addActor(tx, movieID, req.PersonID)
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		writeCastCredit(db, c, http.StatusCreated, movieID, role.ID)
+	}
+}
+
+// UpdateCastCredit godoc
+// @Summary Update a cast credit
+// @Description Change the character of a cast credit or move it to another billing position
+// @Tags credits
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param creditId path int true "Credit ID"
+// @Param credit body updateCastRequest true "Fields to change"
+// @Success 200 {object} CastCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/cast/{creditId} [patch]
+func UpdateCastCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req updateCastRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+		creditID, ok := creditIDParam(c)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			roles, err := castOrder(tx, movieID)
+			if err != nil {
+				return err
+			}
+			index := -1
+			for i, role := range roles {
+				if role.ID == creditID {
+					index = i
+				}
+			}
+			if index < 0 {
+				return errCreditNotFound
+			}
+
+			if req.Character != nil {
+				if err := validateCharacter(*req.Character); err != nil {
+					return err
+				}
+				err := tx.Model(&roles[index]).Update("character", strings.TrimSpace(*req.Character)).Error
+				if err != nil {
+					return err
+				}
+			}
+			if req.Position != nil {
+				if *req.Position < 1 || *req.Position > len(roles) {
+					return &movieInputError{fmt.Sprintf("position must be between 1 and %d", len(roles))}
+				}
+				role := roles[index]
+				roles = append(roles[:index], roles[index+1:]...)
+				position := *req.Position - 1
+				roles = append(roles[:position], append([]models.Role{role}, roles[position:]...)...)
+			}
+			return renumberCast(tx, roles)
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		writeCastCredit(db, c, http.StatusOK, movieID, creditID)
+	}
+}
+
+// ReorderCast godoc
+// @Summary Reorder the cast
+// @Description Set the billing order of a movie's cast. credit_ids must list every cast credit of the movie once.
+// @Tags credits
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param order body reorderCastRequest true "Credit IDs in billing order"
+// @Success 200 {array} CastCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/cast [put]
+func ReorderCast(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req reorderCastRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			roles, err := castOrder(tx, movieID)
+			if err != nil {
+				return err
+			}
+			byID := make(map[uint]models.Role, len(roles))
+			for _, role := range roles {
+				byID[role.ID] = role
+			}
+			if len(req.CreditIDs) != len(roles) {
+				return &movieInputError{fmt.Sprintf("credit_ids must list all %d cast credits", len(roles))}
+			}
+			ordered := make([]models.Role, 0, len(roles))
+			for _, id := range req.CreditIDs {
+				role, ok := byID[id]
+				if !ok {
+					return &movieInputError{fmt.Sprintf("credit %d is not in the cast or is listed twice", id)}
+				}
+				delete(byID, id)
+				ordered = append(ordered, role)
+			}
+			return renumberCast(tx, ordered)
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		cast, err := loadCast(db, movieID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cast"})
+			return
+		}
+		c.JSON(http.StatusOK, cast)
+	}
+}
+
+// RemoveCastCredit godoc
+// @Summary Remove a cast credit
+// @Description Remove a cast credit and close the gap in the billing. An actor left without credits is removed from the movie's actors.
+// @Tags credits
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param creditId path int true "Credit ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/cast/{creditId} [delete]
+func RemoveCastCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+		creditID, ok := creditIDParam(c)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var role models.Role
+			if err := tx.Where("movie_id = ?", movieID).First(&role, creditID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errCreditNotFound
+				}
+				return err
+			}
+			if err := tx.Delete(&role).Error; err != nil {
+				return err
+			}
+
+			var remaining int64
+			err := tx.Model(&models.Role{}).Where("movie_id = ? AND person_id = ?", movieID, role.PersonID).Count(&remaining).Error
+			if err != nil {
+				return err
+			}
+			if remaining == 0 {
+				err := tx.Delete(&models.MovieActor{MovieID: movieID, PersonID: role.PersonID}).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			roles, err := castOrder(tx, movieID)
+			if err != nil {
+				return err
+			}
+			return renumberCast(tx, roles)
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "cast credit removed"})
+	}
+}
+
+// GetCrew godoc
+// @Summary Get the crew of a movie
+// @Description List the crew credits of a movie by department, such as producers, composers and cinematographers
+// @Tags credits
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Success 200 {array} CrewCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/crew [get]
+func GetCrew(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+
+		crew, err := loadCrew(db, movieID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crew"})
+			return
+		}
+
+		c.JSON(http.StatusOK, crew)
+	}
+}
+
+// checkCrewCredit validates a crew credit and refuses one the person
+// already has on the movie.
+func checkCrewCredit(tx *gorm.DB, credit models.CrewCredit) error {
+	if !credit.Department.Valid() {
+		return &movieInputError{fmt.Sprintf("unknown department %q", credit.Department)}
+	}
+	if len(credit.Job) > 100 {
+		return &movieInputError{"job must be at most 100 characters"}
+	}
+	var count int64
+	err := tx.Model(&models.CrewCredit{}).
+		Where("movie_id = ? AND person_id = ? AND department = ? AND LOWER(job) = LOWER(?) AND id <> ?",
+			credit.MovieID, credit.PersonID, credit.Department, credit.Job, credit.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errDuplicateCredit
+	}
+	return nil
+}
+
+// writeCrewCredit responds with the credit of the crew of the movie.
+func writeCrewCredit(db *gorm.DB, c *gin.Context, status int, movieID, creditID uint) {
+	crew, err := loadCrew(db, movieID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch crew"})
+		return
+	}
+	for _, credit := range crew {
+		if credit.CreditID == creditID {
+			c.JSON(status, credit)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": errCreditNotFound.Error()})
+}
+
+// AddCrewCredit godoc
+// @Summary Add a crew credit
+// @Description Credit a person in a department: producer, composer, cinematographer, editor, production_designer, costume_designer, casting, sound or visual_effects, with an optional job title
+// @Tags credits
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param credit body addCrewRequest true "Crew credit"
+// @Success 201 {object} CrewCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/crew [post]
+func AddCrewCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req addCrewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+
+		credit := models.CrewCredit{
+			MovieID:    movieID,
+			PersonID:   req.PersonID,
+			Department: req.Department,
+			Job:        strings.TrimSpace(req.Job),
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := findPerson(tx, req.PersonID); err != nil {
+				return err
+			}
+			if err := checkCrewCredit(tx, credit); err != nil {
+				return err
+			}
+			return tx.Omit("Movie", "Person").Create(&credit).Error
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		writeCrewCredit(db, c, http.StatusCreated, movieID, credit.ID)
+	}
+}
+
+// UpdateCrewCredit godoc
+// @Summary Update a crew credit
+// @Description Change the department or job of a crew credit
+// @Tags credits
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param creditId path int true "Credit ID"
+// @Param credit body updateCrewRequest true "Fields to change"
+// @Success 200 {object} CrewCredit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/crew/{creditId} [patch]
+func UpdateCrewCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req updateCrewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+		creditID, ok := creditIDParam(c)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var credit models.CrewCredit
+			if err := tx.Where("movie_id = ?", movieID).First(&credit, creditID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errCreditNotFound
+				}
+				return err
+			}
+			if req.Department != nil {
+				credit.Department = *req.Department
+			}
+			if req.Job != nil {
+				credit.Job = strings.TrimSpace(*req.Job)
+			}
+			if err := checkCrewCredit(tx, credit); err != nil {
+				return err
+			}
+			return tx.Model(&credit).Updates(map[string]interface{}{"department": credit.Department, "job": credit.Job}).Error
+		})
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		writeCrewCredit(db, c, http.StatusOK, movieID, creditID)
+	}
+}
+
+// RemoveCrewCredit godoc
+// @Summary Remove a crew credit
+// @Tags credits
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param creditId path int true "Credit ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/crew/{creditId} [delete]
+func RemoveCrewCredit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		movieID, ok := movieIDParam(db, c)
+		if !ok {
+			return
+		}
+		creditID, ok := creditIDParam(c)
+		if !ok {
+			return
+		}
+
+		// Deleting the loaded credit lets the autocomplete index refresh
+		// the person's popularity.
+		var credit models.CrewCredit
+		if err := db.Where("movie_id = ?", movieID).First(&credit, creditID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": errCreditNotFound.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove crew credit"})
+			return
+		}
+		if err := db.Delete(&credit).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove crew credit"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "crew credit removed"})
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"movie-api/internal/models"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// FacetCount is the number of matching movies with one value of a facet.
+// ID is set for facets over other tables, like genres.
+type FacetCount struct {
+	ID    *uint  `json:"id,omitempty"`
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// movieFacets maps the facet names to the query that counts the matching
+// movies per value. Each one reads from the matched table, which holds the
+// id, year, country_id and average_rating of every movie that passed the
+// filters.
+var movieFacets = map[string]string{
+	"genre": `SELECT 'genre' AS facet, genres.id AS id, genres.name AS value, COUNT(*) AS count
+		FROM matched JOIN movie_genres ON movie_genres.movie_id = matched.id
+		JOIN genres ON genres.id = movie_genres.genre_id AND genres.deleted_at IS NULL
+		GROUP BY genres.id`,
+	"language": `SELECT 'language' AS facet, languages.id AS id, languages.name AS value, COUNT(*) AS count
+		FROM matched JOIN movie_languages ON movie_languages.movie_id = matched.id
+		JOIN languages ON languages.id = movie_languages.language_id AND languages.deleted_at IS NULL
+		GROUP BY languages.id`,
+	"country": `SELECT 'country' AS facet, countries.id AS id, countries.name AS value, COUNT(*) AS count
+		FROM matched JOIN countries ON countries.id = matched.country_id AND countries.deleted_at IS NULL
+		GROUP BY countries.id`,
+	"decade": `SELECT 'decade' AS facet, NULL AS id, CAST(matched.year / 10 * 10 AS TEXT) AS value, COUNT(*) AS count
+		FROM matched GROUP BY matched.year / 10`,
+	"rating": `SELECT 'rating' AS facet, NULL AS id,
+		COALESCE(CAST(CAST(matched.average_rating AS INTEGER) AS TEXT), 'unrated') AS value, COUNT(*) AS count
+		FROM matched GROUP BY value`,
+}
+
+// parseFacets reads the comma separated facets parameter.
+func parseFacets(s string) ([]string, error) {
+	facets := splitList(s)
+	for _, facet := range facets {
+		if _, ok := movieFacets[facet]; !ok {
+			return nil, fmt.Errorf("unknown facet %q, use genre, decade, country, language or rating", facet)
+		}
+	}
+	return facets, nil
+}
+
+// countMovieFacets counts the movies that pass the filter per value of
+// every facet, in one statement over the filtered movies.
+func countMovieFacets(db *gorm.DB, filter movieFilter, facets []string) (map[string][]FacetCount, error) {
+	if len(facets) == 0 {
+		return nil, nil
+	}
+
+	matched := filter.apply(db.Model(&models.Movie{}))
+	if !filter.needsStats() {
+		matched = matched.Joins(movieStatsJoin)
+	}
+	matched = matched.Select("movies.id, movies.year, movies.country_id, stats.average_rating")
+
+	selects := make([]string, 0, len(facets))
+	for _, facet := range facets {
+		if !slices.Contains(selects, movieFacets[facet]) {
+			selects = append(selects, movieFacets[facet])
+		}
+	}
+
+	var rows []struct {
+		Facet string
+		ID    *uint
+		Value string
+		Count int64
+	}
+	err := db.Raw("WITH matched AS (?) "+strings.Join(selects, " UNION ALL "), matched).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string][]FacetCount, len(facets))
+	for _, facet := range facets {
+		counts[facet] = []FacetCount{}
+	}
+	for _, row := range rows {
+		counts[row.Facet] = append(counts[row.Facet], FacetCount{ID: row.ID, Value: row.Value, Count: row.Count})
+	}
+
+	for facet, values := range counts {
+		switch facet {
+		case "decade", "rating":
+			// Ordered by value, with unrated movies last.
+			sort.Slice(values, func(i, j int) bool {
+				a, errA := strconv.Atoi(values[i].Value)
+				b, errB := strconv.Atoi(values[j].Value)
+				if errA != nil || errB != nil {
+					return errB != nil && errA == nil
+				}
+				return a < b
+			})
+		default:
+			sort.Slice(values, func(i, j int) bool {
+				if values[i].Count != values[j].Count {
+					return values[i].Count > values[j].Count
+				}
+				return values[i].Value < values[j].Value
+			})
+		}
+	}
+	return counts, nil
+}
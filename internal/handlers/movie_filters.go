@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// movieStatsJoin adds the review statistics of every movie as
+// stats.average_rating and stats.review_count. Movies without reviews have
+// NULL statistics.
+const movieStatsJoin = "LEFT JOIN (SELECT movie_id, AVG(rating) AS average_rating, COUNT(*) AS review_count " +
+	"FROM reviews WHERE deleted_at IS NULL GROUP BY movie_id) AS stats ON stats.movie_id = movies.id"
+
+// idsOrNames is a filter list whose entries are either IDs or names.
+type idsOrNames struct {
+	IDs   []uint
+	Names []string
+}
+
+func (l idsOrNames) empty() bool {
+	return len(l.IDs) == 0 && len(l.Names) == 0
+}
+
+// movieFilter holds the filters of the movie list. Filters combine with
+// AND; the entries of one list filter combine with OR.
+type movieFilter struct {
+	YearMin, YearMax       *int
+	RuntimeMin, RuntimeMax *int
+	MinRating              *float64
+	MinReviews             *int
+	Genres                 idsOrNames
+	Countries              idsOrNames
+	Languages              idsOrNames
+	Directors              []uint
+	Writers                []uint
+	Actors                 []uint
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseIntParam(c *gin.Context, name string) (*int, error) {
+	s := c.Query(name)
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+	return &n, nil
+}
+
+func parseIDList(c *gin.Context, name string) ([]uint, error) {
+	var ids []uint
+	for _, item := range splitList(c.Query(name)) {
+		id, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a comma separated list of IDs", name)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// parseIDOrNameList splits a list into IDs and names. Names are matched
+// case-insensitively.
+func parseIDOrNameList(c *gin.Context, name string) idsOrNames {
+	var list idsOrNames
+	for _, item := range splitList(c.Query(name)) {
+		if id, err := strconv.ParseUint(item, 10, 32); err == nil {
+			list.IDs = append(list.IDs, uint(id))
+		} else {
+			list.Names = append(list.Names, strings.ToLower(item))
+		}
+	}
+	return list
+}
+
+// parseMovieFilter reads the movie list filters from the query string.
+func parseMovieFilter(c *gin.Context) (movieFilter, error) {
+	var f movieFilter
+	var err error
+
+	for name, dest := range map[string]**int{
+		"year_min":    &f.YearMin,
+		"year_max":    &f.YearMax,
+		"runtime_min": &f.RuntimeMin,
+		"runtime_max": &f.RuntimeMax,
+		"min_reviews": &f.MinReviews,
+	} {
+		if *dest, err = parseIntParam(c, name); err != nil {
+			return f, err
+		}
+	}
+	if s := c.Query("min_rating"); s != "" {
+		rating, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return f, fmt.Errorf("min_rating must be a number")
+		}
+		f.MinRating = &rating
+	}
+
+	for name, dest := range map[string]*[]uint{
+		"director": &f.Directors,
+		"writer":   &f.Writers,
+		"actor":    &f.Actors,
+	} {
+		if *dest, err = parseIDList(c, name); err != nil {
+			return f, err
+		}
+	}
+	f.Genres = parseIDOrNameList(c, "genre")
+	f.Countries = parseIDOrNameList(c, "country")
+	f.Languages = parseIDOrNameList(c, "language")
+	return f, nil
+}
+
+// needsStats reports whether the filter uses the review statistics, which
+// requires movieStatsJoin.
+func (f movieFilter) needsStats() bool {
+	return f.MinRating != nil || f.MinReviews != nil
+}
+
+// apply adds the filter to a query on movies. The statistics join is
+// added when needed.
+func (f movieFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.YearMin != nil {
+		query = query.Where("movies.year >= ?", *f.YearMin)
+	}
+	if f.YearMax != nil {
+		query = query.Where("movies.year <= ?", *f.YearMax)
+	}
+	if f.RuntimeMin != nil {
+		query = query.Where("movies.runtime >= ?", *f.RuntimeMin)
+	}
+	if f.RuntimeMax != nil {
+		query = query.Where("movies.runtime <= ?", *f.RuntimeMax)
+	}
+
+	if !f.Genres.empty() {
+		query = query.Where("EXISTS (SELECT 1 FROM movie_genres JOIN genres ON genres.id = movie_genres.genre_id "+
+			"WHERE movie_genres.movie_id = movies.id AND genres.deleted_at IS NULL AND (genres.id IN ? OR LOWER(genres.name) IN ?))",
+			f.Genres.IDs, f.Genres.Names)
+	}
+	if !f.Languages.empty() {
+		query = query.Where("EXISTS (SELECT 1 FROM movie_languages JOIN languages ON languages.id = movie_languages.language_id "+
+			"WHERE movie_languages.movie_id = movies.id AND languages.deleted_at IS NULL AND (languages.id IN ? OR LOWER(languages.name) IN ?))",
+			f.Languages.IDs, f.Languages.Names)
+	}
+	if !f.Countries.empty() {
+		query = query.Where("movies.country_id IN (SELECT id FROM countries WHERE deleted_at IS NULL AND (id IN ? OR LOWER(name) IN ?))",
+			f.Countries.IDs, f.Countries.Names)
+	}
+	if len(f.Directors) > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM movie_directors WHERE movie_directors.movie_id = movies.id AND movie_directors.person_id IN ?)", f.Directors)
+	}
+	if len(f.Writers) > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM movie_writers WHERE movie_writers.movie_id = movies.id AND movie_writers.person_id IN ?)", f.Writers)
+	}
+	if len(f.Actors) > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM movie_actors WHERE movie_actors.movie_id = movies.id AND movie_actors.person_id IN ?)", f.Actors)
+	}
+
+	if f.needsStats() {
+		query = query.Joins(movieStatsJoin)
+	}
+	if f.MinRating != nil {
+		query = query.Where("COALESCE(stats.average_rating, 0) >= ?", *f.MinRating)
+	}
+	if f.MinReviews != nil {
+		query = query.Where("COALESCE(stats.review_count, 0) >= ?", *f.MinReviews)
+	}
+	return query
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"movie-api/internal/models"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,55 +21,169 @@ import (
 type MovieResponse struct {
 	ID            uint    `json:"id"`
 	Title         string  `json:"title"`
+	Year          int     `json:"year"`
 	Description   string  `json:"description"`
 	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int     `json:"review_count"`
+}
+
+// movieSortFields are the fields GetMovies can sort by.
+var movieSortFields = map[string]sortField{
+	"title":          {Expr: "movies.title COLLATE NOCASE"},
+	"year":           {Expr: "movies.year"},
+	"runtime":        {Expr: "movies.runtime", Nullable: true},
+	"budget":         {Expr: "movies.budget", Nullable: true},
+	"gross":          {Expr: "movies.gross", Nullable: true},
+	"average_rating": {Expr: "COALESCE(stats.average_rating, 0)"},
+	"review_count":   {Expr: "COALESCE(stats.review_count, 0)"},
+}
+
+// NamedRef is a genre, language, country or person a movie refers to.
+type NamedRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
 }
 
 type MovieDetailResponse struct {
-	ID            uint      `json:"id"`
-	Title         string    `json:"title"`
-	Description   string    `json:"description"`
-	ReleaseDate   string    `json:"release_date"`
-	AverageRating float64   `json:"average_rating"`
+	ID            uint         `json:"id"`
+	Title         string       `json:"title"`
+	Year          int          `json:"year"`
+	Runtime       *int         `json:"runtime"`
+	Tagline       *string      `json:"tagline"`
+	Description   string       `json:"description"`
+	Budget        *int64       `json:"budget"`
+	Gross         *int64       `json:"gross"`
+	Metascore     *int         `json:"metascore"`
+	Country       *NamedRef    `json:"country"`
+	Languages     []NamedRef   `json:"languages"`
+	Genres        []NamedRef   `json:"genres"`
+	Directors     []NamedRef   `json:"directors"`
+	Writers       []NamedRef   `json:"writers"`
+	Cast          []CastCredit `json:"cast"`
+	Crew          []CrewCredit `json:"crew"`
+	AverageRating float64      `json:"average_rating"`
+	RatingCount   int          `json:"rating_count"`
+	ReviewCount   int          `json:"review_count"`
 }
 
 // GetMovies godoc
 // @Summary Get list of movies
-// @Description Get all movies with average ratings
+// @Description Get a page of movies with average ratings. Filters combine with AND, and the entries of a comma separated list with OR. Pass the next_cursor of a page as cursor to get the following one.
 // @Tags movies
 // @Produce json
-// @Success 200 {array} MovieResponse
-// @Failure 500 {object} map[string]string
+// @Param year_min query int false "Earliest release year"
+// @Param year_max query int false "Latest release year"
+// @Param genre query string false "Genre IDs or names, comma separated"
+// @Param director query string false "Director person IDs, comma separated"
+// @Param writer query string false "Writer person IDs, comma separated"
+// @Param actor query string false "Actor person IDs, comma separated"
+// @Param country query string false "Country IDs or names, comma separated"
+// @Param language query string false "Language IDs or names, comma separated"
+// @Param runtime_min query int false "Minimum runtime in minutes"
+// @Param runtime_max query int false "Maximum runtime in minutes"
+// @Param min_rating query number false "Minimum average review rating"
+// @Param min_reviews query int false "Minimum number of reviews"
+// @Param facets query string false "Comma separated facets to count over all matching movies: genre, decade, country, language, rating"
+// @Param sort query string false "Comma separated sort fields, - for descending: title, year, runtime, budget, gross, average_rating, review_count"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of movies to skip"
+// @Param cursor query string false "Cursor from a previous page"
+// @Success 200 {object} ListResponse[MovieResponse]
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
 // @Router /movies [get]
 func GetMovies(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var movies []MovieResponse
+		params, err := parsePageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		result := db.Model(&models.Movie{}).
-			Select("movies.id, movies.title, movies.description, "+
-				"COALESCE(AVG(reviews.rating), 0) as average_rating").
-			Joins("LEFT JOIN reviews ON reviews.movie_id = movies.id").
-			Group("movies.id").
-			Scan(&movies)
+		filter, err := parseMovieFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		if result.Error != nil {
+		facets, err := parseFacets(c.Query("facets"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		order, sortedBy, err := parseSort(c, movieSortFields, "movies.id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := filter.apply(db.Model(&models.Movie{}))
+		if !filter.needsStats() &&
+			(slices.Contains(sortedBy, "average_rating") || slices.Contains(sortedBy, "review_count")) {
+			query = query.Joins(movieStatsJoin)
+		}
+		query = query.Session(&gorm.Session{})
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movies"})
 			return
 		}
 
-		c.JSON(http.StatusOK, movies)
+		ids, next, err := paginate(query, order, params)
+		if err != nil {
+			pageError(c, err, "movies")
+			return
+		}
+
+		movies, err := loadMovieResponses(db, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movies"})
+			return
+		}
+
+		facetCounts, err := countMovieFacets(db, filter, facets)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count facets"})
+			return
+		}
+
+		writePage(c, movies, params, total, next, facetCounts)
 	}
 }
 
+// loadMovieResponses loads the list entries of the movies in the order of
+// ids. Ratings are only aggregated for these movies.
+func loadMovieResponses(db *gorm.DB, ids []uint) ([]MovieResponse, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var movies []MovieResponse
+	err := db.Model(&models.Movie{}).
+		Select("movies.id, movies.title, movies.year, COALESCE(movies.description, '') as description, "+
+			"COALESCE(stats.average_rating, 0) as average_rating, COALESCE(stats.review_count, 0) as review_count").
+		Joins("LEFT JOIN (SELECT movie_id, AVG(rating) AS average_rating, COUNT(*) AS review_count FROM reviews "+
+			"WHERE deleted_at IS NULL AND movie_id IN ? GROUP BY movie_id) AS stats ON stats.movie_id = movies.id", ids).
+		Where("movies.id IN ?", ids).
+		Scan(&movies).Error
+	if err != nil {
+		return nil, err
+	}
+	return orderByIDs(ids, movies, func(m MovieResponse) uint { return m.ID }), nil
+}
+
 // GetMovieDetails godoc
 // @Summary Get movie details
-// @Description Get detailed information about a specific movie
+// @Description Get a movie with its metadata, genres, languages, country, directors, writers, cast with characters and review statistics
 // @Tags movies
 // @Produce json
 // @Param id path int true "Movie ID"
 // @Success 200 {object} MovieDetailResponse
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
 // @Router /movies/{id} [get]
 func GetMovieDetails(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -72,24 +193,126 @@ func GetMovieDetails(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var movie MovieDetailResponse
-		result := db.Model(&models.Movie{}).
-			Select("movies.id, movies.title, movies.description, "+
-				"movies.year, COALESCE(AVG(reviews.rating), 0) as average_rating").
-			Joins("LEFT JOIN reviews ON reviews.movie_id = movies.id").
-			Where("movies.id = ?", id).
-			Group("movies.id").
-			First(&movie)
-
-		if result.Error != nil {
+		movie, err := loadMovieDetail(db, uint(id))
+		if errors.Is(err, errMovieNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Movie not found"})
 			return
 		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movie"})
+			return
+		}
 
 		c.JSON(http.StatusOK, movie)
 	}
 }
 
+// loadMovieDetail loads the movie with everything it refers to in four
+// queries: the movie with its country and review statistics, its genres,
+// languages, directors and writers, its cast and its crew.
+func loadMovieDetail(db *gorm.DB, id uint) (MovieDetailResponse, error) {
+	var row struct {
+		ID            uint
+		Title         string
+		Year          int
+		Runtime       *int
+		Tagline       *string
+		Description   string
+		Budget        *int64
+		Gross         *int64
+		Metascore     *int
+		CountryID     *uint
+		CountryName   *string
+		AverageRating float64
+		RatingCount   int
+		ReviewCount   int
+	}
+	result := db.Model(&models.Movie{}).
+		Select("movies.id, movies.title, movies.year, movies.runtime, movies.tagline, "+
+			"COALESCE(movies.description, '') AS description, movies.budget, movies.gross, movies.metascore, "+
+			"countries.id AS country_id, countries.name AS country_name, "+
+			"COALESCE(stats.average_rating, 0) AS average_rating, "+
+			"COALESCE(stats.rating_count, 0) AS rating_count, COALESCE(stats.review_count, 0) AS review_count").
+		Joins("LEFT JOIN countries ON countries.id = movies.country_id AND countries.deleted_at IS NULL").
+		Joins("LEFT JOIN (SELECT movie_id, AVG(rating) AS average_rating, COUNT(rating) AS rating_count, "+
+			"COUNT(*) AS review_count FROM reviews WHERE deleted_at IS NULL AND movie_id = ? GROUP BY movie_id) AS stats "+
+			"ON stats.movie_id = movies.id", id).
+		Where("movies.id = ?", id).
+		Limit(1).
+		Scan(&row)
+	if result.Error != nil {
+		return MovieDetailResponse{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return MovieDetailResponse{}, errMovieNotFound
+	}
+
+	movie := MovieDetailResponse{
+		ID:            row.ID,
+		Title:         row.Title,
+		Year:          row.Year,
+		Runtime:       row.Runtime,
+		Tagline:       row.Tagline,
+		Description:   row.Description,
+		Budget:        row.Budget,
+		Gross:         row.Gross,
+		Metascore:     row.Metascore,
+		AverageRating: row.AverageRating,
+		RatingCount:   row.RatingCount,
+		ReviewCount:   row.ReviewCount,
+	}
+	if row.CountryID != nil && row.CountryName != nil {
+		movie.Country = &NamedRef{ID: *row.CountryID, Name: *row.CountryName}
+	}
+	movie.Languages, movie.Genres = []NamedRef{}, []NamedRef{}
+	movie.Directors, movie.Writers = []NamedRef{}, []NamedRef{}
+
+	var credits []struct {
+		Kind string
+		ID   uint
+		Name string
+	}
+	err := db.Raw(`SELECT kind, id, name FROM (SELECT 'genre' AS kind, genres.id, genres.name
+			FROM movie_genres JOIN genres ON genres.id = movie_genres.genre_id AND genres.deleted_at IS NULL
+			WHERE movie_genres.movie_id = @id
+		UNION ALL SELECT 'language', languages.id, languages.name
+			FROM movie_languages JOIN languages ON languages.id = movie_languages.language_id AND languages.deleted_at IS NULL
+			WHERE movie_languages.movie_id = @id
+		UNION ALL SELECT 'director', people.id, people.name
+			FROM movie_directors JOIN people ON people.id = movie_directors.person_id AND people.deleted_at IS NULL
+			WHERE movie_directors.movie_id = @id
+		UNION ALL SELECT 'writer', people.id, people.name
+			FROM movie_writers JOIN people ON people.id = movie_writers.person_id AND people.deleted_at IS NULL
+			WHERE movie_writers.movie_id = @id)
+		ORDER BY kind, name, id`, sql.Named("id", id)).
+		Scan(&credits).Error
+	if err != nil {
+		return MovieDetailResponse{}, err
+	}
+
+	for _, credit := range credits {
+		ref := NamedRef{ID: credit.ID, Name: credit.Name}
+		switch credit.Kind {
+		case "genre":
+			movie.Genres = append(movie.Genres, ref)
+		case "language":
+			movie.Languages = append(movie.Languages, ref)
+		case "director":
+			movie.Directors = append(movie.Directors, ref)
+		case "writer":
+			movie.Writers = append(movie.Writers, ref)
+		}
+	}
+
+	if movie.Cast, err = loadCast(db, id); err != nil {
+		return MovieDetailResponse{}, err
+	}
+	if movie.Crew, err = loadCrew(db, id); err != nil {
+		return MovieDetailResponse{}, err
+	}
+	return movie, nil
+}
+
 // CreateMovie godoc
 // @Summary Create a new movie
 // @Description Create movie with relationships
@@ -101,6 +324,7 @@ func GetMovieDetails(db *gorm.DB) gin.HandlerFunc {
 // @Success 201 {object} models.Movie
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /movies [post]
 func CreateMovie(db *gorm.DB) gin.HandlerFunc {
@@ -111,8 +335,8 @@ func CreateMovie(db *gorm.DB) gin.HandlerFunc {
             return
         }
 
-        if req.Year < 1888 || req.Year > time.Now().Year()+5 {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year"})
+        if err := validateMovie(req.Title, req.Year); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
 
@@ -133,6 +357,12 @@ func CreateMovie(db *gorm.DB) gin.HandlerFunc {
             }
         }()
 
+        if err := checkDuplicateMovie(tx, 0, req.Title, req.Year); err != nil {
+            tx.Rollback()
+            writeMovieError(c, err)
+            return
+        }
+
         if err := handleRelationships(tx, &movie, req); err != nil {
             tx.Rollback()
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -145,7 +375,18 @@ func CreateMovie(db *gorm.DB) gin.HandlerFunc {
             return
         }
 
-        tx.Commit()
+        if len(req.Cast) > 0 {
+            if err := replaceCast(tx, movie.ID, req.Cast); err != nil {
+                tx.Rollback()
+                writeMovieError(c, err)
+                return
+            }
+        }
+
+        if err := tx.Commit().Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create movie"})
+            return
+        }
         c.JSON(http.StatusCreated, movie)
     }
 }
@@ -153,7 +394,7 @@ func CreateMovie(db *gorm.DB) gin.HandlerFunc {
 func handleRelationships(tx *gorm.DB, movie *models.Movie, req CreateMovieRequest) error {
     if len(req.GenreIDs) > 0 {
         var genres []models.Genre
-        if err := tx.Find(&genres, "id IN ?", req.GenreIDs).Error; err != nil {
+        if err := findAllByID(tx, &genres, "genre", req.GenreIDs); err != nil {
             return err
         }
         movie.Genres = genres
@@ -161,7 +402,7 @@ func handleRelationships(tx *gorm.DB, movie *models.Movie, req CreateMovieReques
 
     if len(req.DirectorIDs) > 0 {
         var directors []models.Person
-        if err := tx.Find(&directors, "id IN ?", req.DirectorIDs).Error; err != nil {
+        if err := findAllByID(tx, &directors, "director", req.DirectorIDs); err != nil {
             return err
         }
         movie.Directors = directors
@@ -169,15 +410,15 @@ func handleRelationships(tx *gorm.DB, movie *models.Movie, req CreateMovieReques
 
 	if len(req.WriterIDs) > 0 {
 		var writers []models.Person
-		if err := tx.Find(&writers, "in IN ?", req.WriterIDs).Error; err != nil {
+		if err := findAllByID(tx, &writers, "writer", req.WriterIDs); err != nil {
             return err 
         }
         movie.Writers = writers
 	}
 
-    if len(req.WriterIDs) > 0 {
+    if len(req.ActorIDs) > 0 {
         var actors []models.Person
-        if err := tx.Find(&actors, "in IN ?", req.ActorIDs).Error; err != nil {
+        if err := findAllByID(tx, &actors, "actor", req.ActorIDs); err != nil {
             return err
         }
         movie.Actors = actors
@@ -185,7 +426,7 @@ func handleRelationships(tx *gorm.DB, movie *models.Movie, req CreateMovieReques
 
     if len(req.LanguageIDs) > 0 {
         var languages []models.Language
-        if err := tx.Find(&languages, "in IN ?", req.ActorIDs).Error; err != nil {
+        if err := findAllByID(tx, &languages, "language", req.LanguageIDs); err != nil {
             return err
         }
         movie.Languages = languages
@@ -212,8 +453,601 @@ type CreateMovieRequest struct {
     DirectorIDs []uint `json:"director_ids,omitempty"`
     WriterIDs []uint  `json:"writer_ids,omitempty"`
     ActorIDs  []uint  `json:"actor_ids,omitempty"`
+    Cast      []CastInput `json:"cast,omitempty"`
     CountryID *uint    `json:"country_id,omitempty"`
     LanguageIDs []uint `json:"language_ids,omitempty"`
     Budget    *int64  `json:"budget,omitempty"`
     Gross     *int64  `json:"gross,omitempty"`
-}
\ No newline at end of file
+}
+var (
+	errMovieNotFound  = errors.New("Movie not found")
+	errDuplicateMovie = errors.New("a movie with this title and year already exists")
+)
+
+// movieInputError is a request error found while applying a movie change.
+type movieInputError struct {
+	msg string
+}
+
+func (e *movieInputError) Error() string {
+	return e.msg
+}
+
+// validateMovie checks the fields every movie needs.
+func validateMovie(title string, year int) error {
+	if strings.TrimSpace(title) == "" {
+		return &movieInputError{"title is required"}
+	}
+	if year < 1888 || year > time.Now().Year()+5 {
+		return &movieInputError{"invalid year"}
+	}
+	return nil
+}
+
+// checkDuplicateMovie refuses a title and year that another movie than
+// id already has.
+func checkDuplicateMovie(tx *gorm.DB, id uint, title string, year int) error {
+	var count int64
+	err := tx.Model(&models.Movie{}).
+		Where("LOWER(title) = LOWER(?) AND year = ? AND id <> ?", title, year, id).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errDuplicateMovie
+	}
+	return nil
+}
+
+// findAllByID loads the records with the given IDs into dest and fails
+// when one of them does not exist.
+func findAllByID[T any](tx *gorm.DB, dest *[]T, what string, ids []uint) error {
+	if err := tx.Where("id IN ?", ids).Find(dest).Error; err != nil {
+		return err
+	}
+	unique := map[uint]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(*dest) != len(unique) {
+		return &movieInputError{fmt.Sprintf("unknown %s ID in %v", what, ids)}
+	}
+	return nil
+}
+
+func writeMovieError(c *gin.Context, err error) {
+	var inputErr *movieInputError
+	switch {
+	case errors.As(err, &inputErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errMovieNotFound), errors.Is(err, errCreditNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errDuplicateMovie), errors.Is(err, errDuplicateCredit):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update movie"})
+	}
+}
+
+// associationChange is the change of one association of a movie: either
+// a replacement list or IDs to add and remove.
+type associationChange struct {
+	Replace *[]uint
+	Add     []uint
+	Remove  []uint
+}
+
+// UnmarshalJSON accepts a list of IDs, which replaces the association, or
+// an object {"add": [...], "remove": [...]}.
+func (a *associationChange) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "null" {
+		a.Replace = &[]uint{}
+		return nil
+	}
+	var ids []uint
+	if err := json.Unmarshal(data, &ids); err == nil {
+		a.Replace = &ids
+		return nil
+	}
+	var change struct {
+		Add    []uint `json:"add"`
+		Remove []uint `json:"remove"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&change); err != nil {
+		return errors.New("must be a list of IDs or an object with add and remove lists")
+	}
+	a.Add, a.Remove = change.Add, change.Remove
+	return nil
+}
+
+// movieAssociations maps the ID fields of movie requests to the
+// associations of models.Movie.
+var movieAssociations = []struct {
+	Field       string
+	Association string
+	What        string
+}{
+	{"genre_ids", "Genres", "genre"},
+	{"director_ids", "Directors", "director"},
+	{"writer_ids", "Writers", "writer"},
+	{"actor_ids", "Actors", "actor"},
+	{"language_ids", "Languages", "language"},
+}
+
+// movieChange is a validated update of a movie. Columns maps column names
+// to new values, nil for NULL.
+type movieChange struct {
+	Columns      map[string]interface{}
+	Associations map[string]associationChange
+	// Cast replaces the cast credits when set.
+	Cast *[]CastInput
+}
+
+// movieScalarFields maps the scalar fields of movie requests to columns.
+// Required fields cannot be set to null.
+var movieScalarFields = map[string]struct {
+	Column   string
+	Required bool
+	Decode   func(json.RawMessage) (interface{}, error)
+}{
+	"title":      {"title", true, decodeJSON[string]},
+	"year":       {"year", true, decodeJSON[int]},
+	"runtime":    {"runtime", false, decodeJSON[int]},
+	"plot":       {"description", false, decodeJSON[string]},
+	"tagline":    {"tagline", false, decodeJSON[string]},
+	"country_id": {"country_id", false, decodeJSON[uint]},
+	"budget":     {"budget", false, decodeJSON[int64]},
+	"gross":      {"gross", false, decodeJSON[int64]},
+}
+
+func decodeJSON[T any](raw json.RawMessage) (interface{}, error) {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// parseMoviePatch reads a JSON Merge Patch: fields left out stay as they
+// are and null clears a field. Association fields take a replacement list
+// or add and remove lists.
+func parseMoviePatch(body []byte) (movieChange, error) {
+	change := movieChange{Columns: map[string]interface{}{}, Associations: map[string]associationChange{}}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return change, &movieInputError{"body must be a JSON object"}
+	}
+
+	for name, raw := range fields {
+		if scalar, ok := movieScalarFields[name]; ok {
+			if strings.TrimSpace(string(raw)) == "null" {
+				if scalar.Required {
+					return change, &movieInputError{name + " cannot be null"}
+				}
+				change.Columns[scalar.Column] = nil
+				continue
+			}
+			value, err := scalar.Decode(raw)
+			if err != nil {
+				return change, &movieInputError{fmt.Sprintf("invalid %s", name)}
+			}
+			change.Columns[scalar.Column] = value
+			continue
+		}
+
+		if name == "cast" {
+			cast := []CastInput{}
+			if strings.TrimSpace(string(raw)) != "null" {
+				if err := json.Unmarshal(raw, &cast); err != nil {
+					return change, &movieInputError{"cast must be a list of person_id and character objects"}
+				}
+			}
+			change.Cast = &cast
+			continue
+		}
+
+		found := false
+		for _, assoc := range movieAssociations {
+			if assoc.Field != name {
+				continue
+			}
+			var a associationChange
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return change, &movieInputError{fmt.Sprintf("invalid %s: %v", name, err)}
+			}
+			change.Associations[assoc.Association] = a
+			found = true
+		}
+		if !found {
+			return change, &movieInputError{fmt.Sprintf("unknown field %q", name)}
+		}
+	}
+	return change, nil
+}
+
+// movieReplacement turns a full movie into a change that overwrites every
+// field; fields left out become empty.
+func movieReplacement(req CreateMovieRequest) movieChange {
+	nullable := func(set bool, v interface{}) interface{} {
+		if !set {
+			return nil
+		}
+		return v
+	}
+	change := movieChange{
+		Columns: map[string]interface{}{
+			"title":       req.Title,
+			"year":        req.Year,
+			"runtime":     nullable(req.Runtime != nil, derefOrZero(req.Runtime)),
+			"description": nullable(req.Description != nil, derefOrZero(req.Description)),
+			"tagline":     nullable(req.Tagline != nil, derefOrZero(req.Tagline)),
+			"country_id":  nullable(req.CountryID != nil, derefOrZero(req.CountryID)),
+			"budget":      nullable(req.Budget != nil, derefOrZero(req.Budget)),
+			"gross":       nullable(req.Gross != nil, derefOrZero(req.Gross)),
+		},
+		Associations: map[string]associationChange{},
+	}
+	for assoc, ids := range map[string][]uint{
+		"Genres":    req.GenreIDs,
+		"Directors": req.DirectorIDs,
+		"Writers":   req.WriterIDs,
+		"Actors":    req.ActorIDs,
+		"Languages": req.LanguageIDs,
+	} {
+		ids := append([]uint{}, ids...)
+		change.Associations[assoc] = associationChange{Replace: &ids}
+	}
+	cast := append([]CastInput{}, req.Cast...)
+	change.Cast = &cast
+	return change
+}
+
+func derefOrZero[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+// loadAssociated loads the records of the association with the given IDs.
+func loadAssociated(tx *gorm.DB, association, what string, ids []uint) (interface{}, error) {
+	if association == "Genres" {
+		var genres []models.Genre
+		return genres, findAllByID(tx, &genres, what, ids)
+	}
+	if association == "Languages" {
+		var languages []models.Language
+		return languages, findAllByID(tx, &languages, what, ids)
+	}
+	var people []models.Person
+	return people, findAllByID(tx, &people, what, ids)
+}
+
+// applyMovieChange updates the movie with the ID id and returns it with
+// its associations.
+func applyMovieChange(tx *gorm.DB, id uint, change movieChange) (models.Movie, error) {
+	var movie models.Movie
+	if err := tx.First(&movie, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return movie, errMovieNotFound
+		}
+		return movie, err
+	}
+
+	title, year := movie.Title, movie.Year
+	if v, ok := change.Columns["title"]; ok {
+		title = v.(string)
+	}
+	if v, ok := change.Columns["year"]; ok {
+		year = v.(int)
+	}
+	if err := validateMovie(title, year); err != nil {
+		return movie, err
+	}
+	if title != movie.Title || year != movie.Year {
+		if err := checkDuplicateMovie(tx, movie.ID, title, year); err != nil {
+			return movie, err
+		}
+	}
+	if v, ok := change.Columns["country_id"]; ok && v != nil {
+		var country models.Country
+		if err := tx.First(&country, v).Error; err != nil {
+			return movie, &movieInputError{"invalid country ID"}
+		}
+	}
+
+	if len(change.Columns) > 0 {
+		if err := tx.Model(&movie).Updates(change.Columns).Error; err != nil {
+			return movie, err
+		}
+	}
+
+	for _, assoc := range movieAssociations {
+		a, ok := change.Associations[assoc.Association]
+		if !ok {
+			continue
+		}
+		association := func() *gorm.Association {
+			return tx.Model(&movie).Association(assoc.Association)
+		}
+
+		if a.Replace != nil {
+			if len(*a.Replace) == 0 {
+				if err := association().Clear(); err != nil {
+					return movie, err
+				}
+				continue
+			}
+			records, err := loadAssociated(tx, assoc.Association, assoc.What, *a.Replace)
+			if err != nil {
+				return movie, err
+			}
+			if err := association().Replace(records); err != nil {
+				return movie, err
+			}
+			continue
+		}
+		if len(a.Remove) > 0 {
+			records, err := loadAssociated(tx, assoc.Association, assoc.What, a.Remove)
+			if err != nil {
+				return movie, err
+			}
+			if err := association().Delete(records); err != nil {
+				return movie, err
+			}
+		}
+		if len(a.Add) > 0 {
+			records, err := loadAssociated(tx, assoc.Association, assoc.What, a.Add)
+			if err != nil {
+				return movie, err
+			}
+			if err := association().Append(records); err != nil {
+				return movie, err
+			}
+		}
+	}
+
+	// Credits are billed in the cast list; actors dropped from actor_ids
+	// lose their roles.
+	if change.Cast != nil {
+		if err := replaceCast(tx, movie.ID, *change.Cast); err != nil {
+			return movie, err
+		}
+	}
+	if _, ok := change.Associations["Actors"]; ok {
+		if err := pruneCast(tx, movie.ID); err != nil {
+			return movie, err
+		}
+	}
+
+	err := tx.Preload("Genres").Preload("Directors").Preload("Writers").Preload("Actors").
+		Preload("Languages").Preload("Country").
+		First(&movie, movie.ID).Error
+	return movie, err
+}
+
+// updateMovie runs the change built from the request in one transaction.
+func updateMovie(db *gorm.DB, c *gin.Context, change movieChange) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+		return
+	}
+
+	var movie models.Movie
+	err = db.Transaction(func(tx *gorm.DB) error {
+		movie, err = applyMovieChange(tx, uint(id), change)
+		return err
+	})
+	if err != nil {
+		writeMovieError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, movie)
+}
+
+// ReplaceMovie godoc
+// @Summary Replace a movie
+// @Description Overwrite every field of a movie. Optional fields and relationships left out are cleared.
+// @Tags movies
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param movie body CreateMovieRequest true "Movie data"
+// @Success 200 {object} models.Movie
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id} [put]
+func ReplaceMovie(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateMovieRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateMovie(db, c, movieReplacement(req))
+	}
+}
+
+// PatchMovie godoc
+// @Summary Update a movie
+// @Description Change some fields of a movie with a JSON Merge Patch: fields left out stay as they are and null clears a field. genre_ids, director_ids, writer_ids, actor_ids and language_ids take a list that replaces the relationship or {"add": [...], "remove": [...]}. cast takes a list of {"person_id", "character"} that replaces the cast credits in billing order.
+// @Tags movies
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Movie ID"
+// @Param movie body object true "Fields to change"
+// @Success 200 {object} models.Movie
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id} [patch]
+func PatchMovie(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+			return
+		}
+
+		change, err := parseMoviePatch(body)
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		updateMovie(db, c, change)
+	}
+}
+
+// DeleteMovie godoc
+// @Summary Delete a movie
+// @Description Hide a movie and its reviews. Curators can restore it until an admin purges it.
+// @Tags movies
+// @Security BearerAuth
+// @Param id path int true "Movie ID"
+// @Produce json
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id} [delete]
+func DeleteMovie(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+			return
+		}
+
+		var movie models.Movie
+		if err := db.First(&movie, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Movie not found"})
+			return
+		}
+
+		if err := db.Delete(&movie).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete movie"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "movie deleted"})
+	}
+}
+
+// RestoreMovie godoc
+// @Summary Restore a deleted movie
+// @Description Bring back a deleted movie together with its reviews
+// @Tags movies
+// @Security BearerAuth
+// @Param id path int true "Movie ID"
+// @Produce json
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /movies/{id}/restore [post]
+func RestoreMovie(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var movie models.Movie
+			if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(&movie, id).Error; err != nil {
+				return errMovieNotFound
+			}
+			// A movie with the same title and year may have been added
+			// since.
+			if err := checkDuplicateMovie(tx, movie.ID, movie.Title, movie.Year); err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&movie).Update("deleted_at", nil).Error
+		})
+		if errors.Is(err, errMovieNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted movie not found"})
+			return
+		}
+		if err != nil {
+			writeMovieError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "movie restored"})
+	}
+}
+
+// PurgeMovie godoc
+// @Summary Purge a deleted movie
+// @Description Permanently remove a deleted movie with its reviews, cast and crew credits and relationships (admins only)
+// @Tags movies
+// @Security BearerAuth
+// @Param id path int true "Movie ID"
+// @Produce json
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/movies/{id} [delete]
+func PurgeMovie(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid movie ID"})
+			return
+		}
+
+		var movie models.Movie
+		if err := db.Unscoped().First(&movie, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Movie not found"})
+			return
+		}
+		if !movie.DeletedAt.Valid {
+			c.JSON(http.StatusConflict, gin.H{"error": "delete the movie before purging it"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, table := range []string{"movie_genres", "movie_directors", "movie_writers", "movie_actors", "movie_languages"} {
+				if err := tx.Exec("DELETE FROM "+table+" WHERE movie_id = ?", movie.ID).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Unscoped().Where("movie_id = ?", movie.ID).Delete(&models.Role{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("movie_id = ?", movie.ID).Delete(&models.CrewCredit{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("movie_id = ?", movie.ID).Delete(&models.Review{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&movie).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge movie"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "movie purged"})
+	}
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ListResponse is the body of every paginated list endpoint. Facets are
+// only present on lists that support them, when asked for.
+type ListResponse[T any] struct {
+	Data       []T                     `json:"data"`
+	Pagination Pagination              `json:"pagination"`
+	Facets     map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// Pagination describes the page that was returned. NextCursor is empty on
+// the last page.
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// orderKey is one column of the order of a list. The last key of every
+// order must be unique, usually the primary key, so the order is total and
+// a cursor points at exactly one row.
+type orderKey struct {
+	Expr string
+	Desc bool
+}
+
+// sortField is a field a list can be sorted by. NULLs of nullable fields
+// sort last in both directions.
+type sortField struct {
+	Expr     string
+	Nullable bool
+}
+
+// parseSort reads the sort parameter, a comma separated list of field
+// names each optionally prefixed with "-" for descending order, and
+// returns the order with idExpr appended as the tie-breaker. It also
+// returns the names of the fields used.
+func parseSort(c *gin.Context, fields map[string]sortField, idExpr string) ([]orderKey, []string, error) {
+	var order []orderKey
+	var names []string
+	for _, item := range splitList(c.Query("sort")) {
+		name, desc := strings.CutPrefix(item, "-")
+		field, ok := fields[name]
+		if !ok {
+			valid := make([]string, 0, len(fields))
+			for name := range fields {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			return nil, nil, fmt.Errorf("cannot sort by %q, use one of %s", name, strings.Join(valid, ", "))
+		}
+		if slices.Contains(names, name) {
+			return nil, nil, fmt.Errorf("sort field %q given twice", name)
+		}
+		names = append(names, name)
+
+		if field.Nullable {
+			order = append(order,
+				orderKey{Expr: "(" + field.Expr + " IS NULL)"},
+				orderKey{Expr: "COALESCE(" + field.Expr + ", 0)", Desc: desc})
+		} else {
+			order = append(order, orderKey{Expr: field.Expr, Desc: desc})
+		}
+	}
+	return append(order, orderKey{Expr: idExpr}), names, nil
+}
+
+// pageParams are the pagination query parameters of a list request.
+type pageParams struct {
+	Limit  int
+	Offset int
+	cursor *pageCursor
+}
+
+// pageCursor is the decoded form of the opaque cursor: the order it was
+// issued for and the order key values of the last row of the page.
+type pageCursor struct {
+	Order  string        `json:"o"`
+	Values []cursorValue `json:"v"`
+}
+
+// cursorValue keeps the type of a key value across the JSON round trip, so
+// integers, floats and times compare the same way they did in the query.
+type cursorValue struct {
+	Int    *int64     `json:"i,omitempty"`
+	Float  *float64   `json:"f,omitempty"`
+	String *string    `json:"s,omitempty"`
+	Time   *time.Time `json:"t,omitempty"`
+}
+
+func newCursorValue(v interface{}) (cursorValue, error) {
+	switch v := v.(type) {
+	case int64:
+		return cursorValue{Int: &v}, nil
+	case float64:
+		return cursorValue{Float: &v}, nil
+	case string:
+		return cursorValue{String: &v}, nil
+	case []byte:
+		s := string(v)
+		return cursorValue{String: &s}, nil
+	case time.Time:
+		return cursorValue{Time: &v}, nil
+	}
+	return cursorValue{}, fmt.Errorf("unsupported cursor value %T", v)
+}
+
+func (v cursorValue) value() (interface{}, bool) {
+	switch {
+	case v.Int != nil:
+		return *v.Int, true
+	case v.Float != nil:
+		return *v.Float, true
+	case v.String != nil:
+		return *v.String, true
+	case v.Time != nil:
+		return *v.Time, true
+	}
+	return nil, false
+}
+
+func orderSignature(order []orderKey) string {
+	parts := make([]string, len(order))
+	for i, key := range order {
+		parts[i] = key.Expr
+		if key.Desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeCursor(cursor pageCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || len(cursor.Values) == 0 {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// parsePageParams reads limit, offset and cursor from the query string. A
+// cursor and an offset cannot be combined.
+func parsePageParams(c *gin.Context) (pageParams, error) {
+	params := pageParams{Limit: defaultPageLimit}
+
+	if s := c.Query("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		params.Limit = limit
+	}
+	if s := c.Query("offset"); s != "" {
+		offset, err := strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return params, errors.New("offset must be a non-negative integer")
+		}
+		params.Offset = offset
+	}
+	if s := c.Query("cursor"); s != "" {
+		if c.Query("offset") != "" {
+			return params, errors.New("cursor and offset cannot be combined")
+		}
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return params, err
+		}
+		params.cursor = cursor
+	}
+	return params, nil
+}
+
+// keysetCondition builds the WHERE clause that selects the rows after the
+// cursor values in the given order.
+func keysetCondition(order []orderKey, values []interface{}) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for i, key := range order {
+		var parts []string
+		for _, prev := range order[:i] {
+			parts = append(parts, prev.Expr+" = ?")
+		}
+		op := " > ?"
+		if key.Desc {
+			op = " < ?"
+		}
+		parts = append(parts, key.Expr+op)
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+		args = append(args, values[:i+1]...)
+	}
+	return strings.Join(clauses, " OR "), args
+}
+
+// paginate selects the IDs of one page of query in the given order, whose
+// last key must be the ID. It returns the IDs in order and the cursor for
+// the next page, which is empty on the last page. The caller loads the
+// rows for the IDs.
+func paginate(query *gorm.DB, order []orderKey, params pageParams) ([]uint, string, error) {
+	signature := orderSignature(order)
+
+	if params.cursor != nil {
+		if params.cursor.Order != signature || len(params.cursor.Values) != len(order) {
+			return nil, "", errInvalidCursor
+		}
+		values := make([]interface{}, len(order))
+		for i, v := range params.cursor.Values {
+			value, ok := v.value()
+			if !ok {
+				return nil, "", errInvalidCursor
+			}
+			values[i] = value
+		}
+		condition, args := keysetCondition(order, values)
+		query = query.Where(condition, args...)
+	} else if params.Offset > 0 {
+		query = query.Offset(params.Offset)
+	}
+
+	selects := make([]string, len(order))
+	orders := make([]string, len(order))
+	for i, key := range order {
+		selects[i] = fmt.Sprintf("%s AS page_key_%d", key.Expr, i)
+		orders[i] = key.Expr
+		if key.Desc {
+			orders[i] += " DESC"
+		}
+	}
+
+	// One row more than requested tells whether there is a next page.
+	rows, err := query.Select(strings.Join(selects, ", ")).
+		Order(strings.Join(orders, ", ")).
+		Limit(params.Limit + 1).
+		Rows()
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var ids []uint
+	var last []interface{}
+	for rows.Next() {
+		if len(ids) == params.Limit {
+			cursor := pageCursor{Order: signature, Values: make([]cursorValue, len(last))}
+			for i, v := range last {
+				if cursor.Values[i], err = newCursorValue(v); err != nil {
+					return nil, "", err
+				}
+			}
+			next, err := encodeCursor(cursor)
+			return ids, next, err
+		}
+
+		values := make([]interface{}, len(order))
+		dest := make([]interface{}, len(order))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, "", err
+		}
+		id, ok := values[len(values)-1].(int64)
+		if !ok {
+			return nil, "", fmt.Errorf("unexpected id %T", values[len(values)-1])
+		}
+		ids = append(ids, uint(id))
+		last = values
+	}
+	return ids, "", rows.Err()
+}
+
+// orderByIDs sorts rows loaded with "id IN ?" into the order of ids.
+func orderByIDs[T any](ids []uint, rows []T, id func(T) uint) []T {
+	byID := make(map[uint]T, len(rows))
+	for _, row := range rows {
+		byID[id(row)] = row
+	}
+	ordered := make([]T, 0, len(ids))
+	for _, id := range ids {
+		if row, ok := byID[id]; ok {
+			ordered = append(ordered, row)
+		}
+	}
+	return ordered
+}
+
+// pageLink returns the request URL with the pagination parameters replaced.
+func pageLink(c *gin.Context, set map[string]string) string {
+	u := *c.Request.URL
+	query := u.Query()
+	query.Del("cursor")
+	query.Del("offset")
+	for k, v := range set {
+		query.Set(k, v)
+	}
+	u.RawQuery = query.Encode()
+	return (&url.URL{Path: u.Path, RawQuery: u.RawQuery}).String()
+}
+
+// writePage sends a page of a list with X-Total-Count and Link headers.
+// Requests by offset get offset links, all others cursor links.
+func writePage[T any](c *gin.Context, data []T, params pageParams, total int64, next string, facets map[string][]FacetCount) {
+	if data == nil {
+		data = []T{}
+	}
+
+	limit := strconv.Itoa(params.Limit)
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageLink(c, map[string]string{"limit": limit}))}
+	byOffset := params.cursor == nil && c.Query("offset") != ""
+	if byOffset {
+		if params.Offset > 0 {
+			prev := params.Offset - params.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, fmt.Sprintf(`<%s>; rel="prev"`,
+				pageLink(c, map[string]string{"limit": limit, "offset": strconv.Itoa(prev)})))
+		}
+		if next != "" {
+			links = append(links, fmt.Sprintf(`<%s>; rel="next"`,
+				pageLink(c, map[string]string{"limit": limit, "offset": strconv.Itoa(params.Offset + params.Limit)})))
+		}
+		if total > 0 {
+			last := (total - 1) / int64(params.Limit) * int64(params.Limit)
+			links = append(links, fmt.Sprintf(`<%s>; rel="last"`,
+				pageLink(c, map[string]string{"limit": limit, "offset": strconv.FormatInt(last, 10)})))
+		}
+	} else if next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`,
+			pageLink(c, map[string]string{"limit": limit, "cursor": next})))
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.Header("Link", strings.Join(links, ", "))
+	c.JSON(http.StatusOK, ListResponse[T]{
+		Data: data,
+		Pagination: Pagination{
+			Limit:      params.Limit,
+			Offset:     params.Offset,
+			Total:      total,
+			NextCursor: next,
+		},
+		Facets: facets,
+	})
+}
+
+// pageError writes the response for an error from parsePageParams or
+// paginate.
+func pageError(c *gin.Context, err error, what string) {
+	if errors.Is(err, errInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + what})
+}
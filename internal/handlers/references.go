@@ -0,0 +1,497 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"movie-api/internal/models"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ReferenceResponse is a genre, person, country or language with the
+// number of movies that refer to it.
+type ReferenceResponse struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	MovieCount int64  `json:"movie_count"`
+}
+
+type referenceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// ReferenceTable describes a table of names that movies refer to. The
+// reference handlers take one of GenreTable, PersonTable, CountryTable and
+// LanguageTable.
+type ReferenceTable struct {
+	what    string
+	table   string
+	maxName int
+	// unique tables refuse a second row with the same name.
+	unique bool
+	// model returns a pointer to a row of the table.
+	model func(id uint, name string) interface{}
+	// movies selects the IDs of the movies referring to the row whose ID is
+	// the format argument.
+	movies string
+	// detach removes the row from every movie referring to it.
+	detach func(tx *gorm.DB, id uint) error
+}
+
+var (
+	GenreTable = &ReferenceTable{
+		what: "genre", table: "genres", maxName: 50, unique: true,
+		model: func(id uint, name string) interface{} {
+			return &models.Genre{Model: gorm.Model{ID: id}, Name: name}
+		},
+		movies: "SELECT movie_id FROM movie_genres WHERE genre_id = %[1]s",
+		detach: detachFrom("movie_genres", "genre_id"),
+	}
+	LanguageTable = &ReferenceTable{
+		what: "language", table: "languages", maxName: 50, unique: true,
+		model: func(id uint, name string) interface{} {
+			return &models.Language{Model: gorm.Model{ID: id}, Name: name}
+		},
+		movies: "SELECT movie_id FROM movie_languages WHERE language_id = %[1]s",
+		detach: detachFrom("movie_languages", "language_id"),
+	}
+	CountryTable = &ReferenceTable{
+		what: "country", table: "countries", maxName: 50, unique: true,
+		model: func(id uint, name string) interface{} {
+			return &models.Country{Model: gorm.Model{ID: id}, Name: name}
+		},
+		movies: "SELECT id FROM movies WHERE country_id = %[1]s",
+		detach: func(tx *gorm.DB, id uint) error {
+			return tx.Unscoped().Model(&models.Movie{}).Where("country_id = ?", id).Update("country_id", nil).Error
+		},
+	}
+	// People share names, so PersonTable is not unique.
+	PersonTable = &ReferenceTable{
+		what: "person", table: "people", maxName: 100,
+		model: func(id uint, name string) interface{} {
+			return &models.Person{Model: gorm.Model{ID: id}, Name: name}
+		},
+		movies: `SELECT movie_id FROM movie_directors WHERE person_id = %[1]s
+			UNION SELECT movie_id FROM movie_writers WHERE person_id = %[1]s
+			UNION SELECT movie_id FROM movie_actors WHERE person_id = %[1]s
+			UNION SELECT movie_id FROM roles WHERE person_id = %[1]s AND deleted_at IS NULL
+			UNION SELECT movie_id FROM crew_credits WHERE person_id = %[1]s AND deleted_at IS NULL`,
+		detach: detachPerson,
+	}
+)
+
+// detachFrom removes the rows of a join table that refer to the row.
+func detachFrom(table, column string) func(tx *gorm.DB, id uint) error {
+	return func(tx *gorm.DB, id uint) error {
+		return tx.Exec("DELETE FROM "+table+" WHERE "+column+" = ?", id).Error
+	}
+}
+
+// detachPerson removes a person from the directors, writers, cast and crew
+// of every movie, closing the gaps their roles leave in the billing.
+func detachPerson(tx *gorm.DB, id uint) error {
+	for _, table := range []string{"movie_directors", "movie_writers", "movie_actors"} {
+		if err := detachFrom(table, "person_id")(tx, id); err != nil {
+			return err
+		}
+	}
+
+	var movieIDs []uint
+	if err := tx.Model(&models.Role{}).Where("person_id = ?", id).Distinct().Pluck("movie_id", &movieIDs).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("person_id = ?", id).Delete(&models.Role{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("person_id = ?", id).Delete(&models.CrewCredit{}).Error; err != nil {
+		return err
+	}
+	for _, movieID := range movieIDs {
+		roles, err := castOrder(tx, movieID)
+		if err != nil {
+			return err
+		}
+		if err := renumberCast(tx, roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movieCount is the SQL expression counting the visible movies that refer
+// to the current row.
+func (t *ReferenceTable) movieCount() string {
+	return fmt.Sprintf("(SELECT COUNT(*) FROM movies WHERE movies.deleted_at IS NULL AND movies.id IN (%s))",
+		fmt.Sprintf(t.movies, t.table+".id"))
+}
+
+// load returns the row with the ID id.
+func (t *ReferenceTable) load(db *gorm.DB, id uint) (ReferenceResponse, error) {
+	var ref ReferenceResponse
+	result := db.Model(t.model(0, "")).
+		Select(fmt.Sprintf("%[1]s.id, %[1]s.name, %[2]s AS movie_count", t.table, t.movieCount())).
+		Where(t.table+".id = ?", id).
+		Scan(&ref)
+	if result.Error != nil {
+		return ref, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ref, gorm.ErrRecordNotFound
+	}
+	return ref, nil
+}
+
+// checkName validates a name and, for unique tables, refuses one that
+// another row than id already has, ignoring case.
+func (t *ReferenceTable) checkName(tx *gorm.DB, id uint, name string) error {
+	if name == "" {
+		return &movieInputError{"name is required"}
+	}
+	if len(name) > t.maxName {
+		return &movieInputError{fmt.Sprintf("name must be at most %d characters", t.maxName)}
+	}
+	if !t.unique {
+		return nil
+	}
+
+	// Deleted rows keep their names in the unique index.
+	var count int64
+	err := tx.Unscoped().Model(t.model(0, "")).
+		Where("LOWER(name) = LOWER(?) AND id <> ?", name, id).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return t.conflict(name)
+	}
+	return nil
+}
+
+// referenceConflictError is a name another row already has.
+type referenceConflictError struct {
+	msg string
+}
+
+func (e *referenceConflictError) Error() string {
+	return e.msg
+}
+
+func (t *ReferenceTable) conflict(name string) error {
+	return &referenceConflictError{fmt.Sprintf("a %s named %q already exists", t.what, name)}
+}
+
+// writeError responds with the status matching err.
+func (t *ReferenceTable) writeError(c *gin.Context, err error) {
+	var inputErr *movieInputError
+	var conflictErr *referenceConflictError
+	switch {
+	case errors.As(err, &inputErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.As(err, &conflictErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s not found", t.what)})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to save %s", t.what)})
+	}
+}
+
+// isUniqueViolation reports whether err is a unique index violation, for
+// a name written by a concurrent request after checkName.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func referenceIDParam(c *gin.Context, t *ReferenceTable) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s ID", t.what)})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ListReferences godoc
+// @Summary List genres, people, countries or languages
+// @Description Get a page of rows, sorted by name unless sort is given. q keeps the names containing it, ignoring case.
+// @Tags references
+// @Produce json
+// @Param q query string false "Part of the name"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of rows to skip"
+// @Param cursor query string false "Cursor from a previous page"
+// @Param sort query string false "Comma separated sort fields, - for descending: name, movie_count"
+// @Success 200 {object} ListResponse[ReferenceResponse]
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /genres [get]
+// @Router /people [get]
+// @Router /countries [get]
+// @Router /languages [get]
+func ListReferences(db *gorm.DB, t *ReferenceTable) gin.HandlerFunc {
+	sortFields := map[string]sortField{
+		"name":        {Expr: t.table + ".name"},
+		"movie_count": {Expr: t.movieCount()},
+	}
+
+	return func(c *gin.Context) {
+		params, err := parsePageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		order, names, err := parseSort(c, sortFields, t.table+".id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(names) == 0 {
+			order = append([]orderKey{{Expr: t.table + ".name"}}, order...)
+		}
+
+		query := db.Model(t.model(0, ""))
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(q))
+			query = query.Where("LOWER("+t.table+".name) LIKE ? ESCAPE '\\'", "%"+escaped+"%")
+		}
+		query = query.Session(&gorm.Session{})
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + t.table})
+			return
+		}
+
+		ids, next, err := paginate(query, order, params)
+		if err != nil {
+			pageError(c, err, t.table)
+			return
+		}
+
+		var refs []ReferenceResponse
+		if len(ids) > 0 {
+			err := db.Model(t.model(0, "")).
+				Select(fmt.Sprintf("%[1]s.id, %[1]s.name, %[2]s AS movie_count", t.table, t.movieCount())).
+				Where(t.table+".id IN ?", ids).
+				Scan(&refs).Error
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + t.table})
+				return
+			}
+		}
+
+		writePage(c, orderByIDs(ids, refs, func(r ReferenceResponse) uint { return r.ID }), params, total, next, nil)
+	}
+}
+
+// GetReference godoc
+// @Summary Get a genre, person, country or language
+// @Tags references
+// @Produce json
+// @Param id path int true "ID"
+// @Success 200 {object} ReferenceResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /genres/{id} [get]
+// @Router /people/{id} [get]
+// @Router /countries/{id} [get]
+// @Router /languages/{id} [get]
+func GetReference(db *gorm.DB, t *ReferenceTable) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := referenceIDParam(c, t)
+		if !ok {
+			return
+		}
+
+		ref, err := t.load(db, id)
+		if err != nil {
+			t.writeError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, ref)
+	}
+}
+
+// CreateReference godoc
+// @Summary Create a genre, person, country or language
+// @Description Genre, country and language names are unique, ignoring case; people can share a name.
+// @Tags references
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param reference body referenceRequest true "Name"
+// @Success 201 {object} ReferenceResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /genres [post]
+// @Router /people [post]
+// @Router /countries [post]
+// @Router /languages [post]
+func CreateReference(db *gorm.DB, t *ReferenceTable) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req referenceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+
+		row := t.model(0, name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := t.checkName(tx, 0, name); err != nil {
+				return err
+			}
+			if err := tx.Create(row).Error; err != nil {
+				if isUniqueViolation(err) {
+					return t.conflict(name)
+				}
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			t.writeError(c, err)
+			return
+		}
+
+		id := reflect.Indirect(reflect.ValueOf(row)).FieldByName("ID").Interface().(uint)
+		c.JSON(http.StatusCreated, ReferenceResponse{ID: id, Name: name})
+	}
+}
+
+// UpdateReference godoc
+// @Summary Rename a genre, person, country or language
+// @Tags references
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID"
+// @Param reference body referenceRequest true "New name"
+// @Success 200 {object} ReferenceResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /genres/{id} [put]
+// @Router /people/{id} [put]
+// @Router /countries/{id} [put]
+// @Router /languages/{id} [put]
+func UpdateReference(db *gorm.DB, t *ReferenceTable) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req referenceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, ok := referenceIDParam(c, t)
+		if !ok {
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(t.model(0, ""), id).Error; err != nil {
+				return err
+			}
+			if err := t.checkName(tx, id, name); err != nil {
+				return err
+			}
+			if err := tx.Model(t.model(id, "")).Update("name", name).Error; err != nil {
+				if isUniqueViolation(err) {
+					return t.conflict(name)
+				}
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			t.writeError(c, err)
+			return
+		}
+
+		ref, err := t.load(db, id)
+		if err != nil {
+			t.writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, ref)
+	}
+}
+
+// DeleteReference godoc
+// @Summary Delete a genre, person, country or language
+// @Description Deleting a row that movies refer to is refused with a 409 unless cascade is true, which first removes it from those movies. Deleted movies count, since they can be restored.
+// @Tags references
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "ID"
+// @Param cascade query bool false "Remove the row from the movies referring to it"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /genres/{id} [delete]
+// @Router /people/{id} [delete]
+// @Router /countries/{id} [delete]
+// @Router /languages/{id} [delete]
+func DeleteReference(db *gorm.DB, t *ReferenceTable) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := referenceIDParam(c, t)
+		if !ok {
+			return
+		}
+		cascade := false
+		if s := c.Query("cascade"); s != "" {
+			var err error
+			if cascade, err = strconv.ParseBool(s); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cascade must be true or false"})
+				return
+			}
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(t.model(0, ""), id).Error; err != nil {
+				return err
+			}
+
+			var used int64
+			err := tx.Raw("SELECT COUNT(*) FROM movies WHERE id IN ("+fmt.Sprintf(t.movies, "@id")+")", sql.Named("id", id)).
+				Scan(&used).Error
+			if err != nil {
+				return err
+			}
+			if used > 0 {
+				if !cascade {
+					return &referenceConflictError{fmt.Sprintf("%d movies refer to this %s; pass cascade=true to remove it from them", used, t.what)}
+				}
+				if err := t.detach(tx, id); err != nil {
+					return err
+				}
+			}
+
+			// Rows are removed for good, so their names can be used again.
+			return tx.Unscoped().Delete(t.model(id, "")).Error
+		})
+		if err != nil {
+			t.writeError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": t.what + " deleted"})
+	}
+}
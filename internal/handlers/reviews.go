@@ -6,6 +6,7 @@ import (
 	"movie-api/internal/auth"
 	"net/http"
 	"strconv"
+	"time"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -16,6 +17,13 @@ type ReviewResponse struct {
 	MovieTitle string `json:"movie_title"`
 	Rating	  float64	 `json:"user_rating"`
 	Text      string `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// reviewSortFields are the fields GetReviews can sort by.
+var reviewSortFields = map[string]sortField{
+	"created_at": {Expr: "reviews.created_at"},
+	"rating":     {Expr: "reviews.rating", Nullable: true},
 }
 
 type CreateReviewRequest struct {
@@ -33,29 +41,65 @@ func (r *CreateReviewRequest) Validate() error {
 
 // GetReviews godoc
 // @Summary Get all reviews
-// @Description Get a list of all reviews with user and movie information.
+// @Description Get a page of reviews with user and movie information. Pass the next_cursor of a page as cursor to get the following one.
 // @Tags review
 // @Produce json
-// @Success 200 {array} ReviewResponse
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of reviews to skip"
+// @Param cursor query string false "Cursor from a previous page"
+// @Param sort query string false "Comma separated sort fields, - for descending: created_at, rating"
+// @Success 200 {object} ListResponse[ReviewResponse]
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /reviews [get]
 func GetReviews(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var reviews []ReviewResponse
+		params, err := parsePageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		result := db.Model(&models.Review{}).
-			Select(`reviews.id, users.username as user_name, movies.title as movie_title, reviews.text, 
-			CASE WHEN reviews.rating IS NOT NULL THEN reviews.rating ELSE NULL END as rating`).
+		order, _, err := parseSort(c, reviewSortFields, "reviews.id")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := db.Model(&models.Review{}).
 			Joins("JOIN users ON users.id = reviews.user_id").
-			Joins("JOIN movies ON movies.id = reviews.movie_id").
-			Scan(&reviews)
+			Joins("JOIN movies ON movies.id = reviews.movie_id AND movies.deleted_at IS NULL").
+			Session(&gorm.Session{})
 
-		if result.Error != nil {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
 			return
 		}
 
-		c.JSON(http.StatusOK, reviews)
+		ids, next, err := paginate(query, order, params)
+		if err != nil {
+			pageError(c, err, "reviews")
+			return
+		}
+
+		var reviews []ReviewResponse
+		if len(ids) > 0 {
+			result := db.Model(&models.Review{}).
+				Select(`reviews.id, CASE WHEN users.deleted_at IS NULL THEN users.username ELSE '[deleted]' END as user_name, movies.title as movie_title, reviews.text, reviews.created_at, 
+				CASE WHEN reviews.rating IS NOT NULL THEN reviews.rating ELSE NULL END as rating`).
+				Joins("JOIN users ON users.id = reviews.user_id").
+				Joins("JOIN movies ON movies.id = reviews.movie_id AND movies.deleted_at IS NULL").
+				Where("reviews.id IN ?", ids).
+				Scan(&reviews)
+
+			if result.Error != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+				return
+			}
+		}
+
+		writePage(c, orderByIDs(ids, reviews, func(r ReviewResponse) uint { return r.ID }), params, total, next, nil)
 	}
 }
 
@@ -79,10 +123,10 @@ func GetReviewDetails(db *gorm.DB) gin.HandlerFunc {
 
 		var review ReviewResponse
 		result := db.Model(&models.Review{}).
-			Select(`reviews.id, users.username as user_name, movies.title as movie_title, reviews.text, 
+			Select(`reviews.id, CASE WHEN users.deleted_at IS NULL THEN users.username ELSE '[deleted]' END as user_name, movies.title as movie_title, reviews.text, reviews.created_at, 
 			CASE WHEN reviews.rating IS NOT NULL THEN reviews.rating ELSE NULL END as rating`).
 			Joins("JOIN users ON users.id = reviews.user_id").
-			Joins("JOIN movies ON movies.id = reviews.movie_id").
+			Joins("JOIN movies ON movies.id = reviews.movie_id AND movies.deleted_at IS NULL").
 			Where("reviews.id = ?", id).
 			First(&review)
 
@@ -152,4 +196,38 @@ func CreateReview(db *gorm.DB) gin.HandlerFunc {
 			"message":    "Review created successfully",
 		})
 	}
+}
+
+// DeleteReview godoc
+// @Summary Delete a review
+// @Description Remove a review (moderators only)
+// @Tags review
+// @Security BearerAuth
+// @Param id path int true "Review ID"
+// @Produce json
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /reviews/{id} [delete]
+func DeleteReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+			return
+		}
+
+		result := db.Delete(&models.Review{}, id)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Review deleted successfully"})
+	}
 }
\ No newline at end of file
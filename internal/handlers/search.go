@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"movie-api/internal/search"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// Search godoc
+// @Summary Search movies and people
+// @Description Full-text search over movie titles, taglines, descriptions and cast and crew names, and over people. Every word must match, as a prefix. Results are grouped by type and ranked with BM25; titles, names and snippets are HTML with the matches in <mark> tags.
+// @Tags search
+// @Produce json
+// @Param q query string true "Search words"
+// @Param limit query int false "Maximum results per type (default 10, max 50)"
+// @Success 200 {object} search.Results
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /search [get]
+func Search(index *search.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultSearchLimit
+		if s := c.Query("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)})
+				return
+			}
+			limit = n
+		}
+
+		results, err := index.Search(c.Query("q"), limit)
+		if errors.Is(err, search.ErrEmptyQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one word"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 20
+)
+
+// Autocomplete godoc
+// @Summary Autocomplete movie titles and names
+// @Description Suggest movies and people whose title or name has a word starting with q, tolerating typos. Closer matches come first, then more reviewed movies and more credited people.
+// @Tags search
+// @Produce json
+// @Param q query string true "What the user typed so far"
+// @Param limit query int false "Maximum suggestions (default 10, max 20)"
+// @Success 200 {array} search.Suggestion
+// @Failure 400 {object} models.ErrorResponse
+// @Router /movies/autocomplete [get]
+func Autocomplete(suggester *search.Suggester) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultSuggestLimit
+		if s := c.Query("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxSuggestLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSuggestLimit)})
+				return
+			}
+			limit = n
+		}
+
+		suggestions, err := suggester.Suggest(c.Query("q"), limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one letter or digit"})
+			return
+		}
+
+		c.JSON(http.StatusOK, suggestions)
+	}
+}
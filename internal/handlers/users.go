@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"log"
+	"movie-api/internal/auth"
+	"movie-api/internal/mail"
+	"movie-api/internal/models"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserProfileResponse struct {
+	ID            uint            `json:"id"`
+	Username      string          `json:"username"`
+	Email         *string         `json:"email"`
+	DisplayName   string          `json:"display_name"`
+	Bio           string          `json:"bio"`
+	AvatarURL     string          `json:"avatar_url"`
+	Role          models.UserRole `json:"role"`
+	EmailVerified bool            `json:"email_verified"`
+	MFAEnabled    bool            `json:"mfa_enabled"`
+	JoinedAt      time.Time       `json:"joined_at"`
+}
+
+type UserStats struct {
+	ReviewCount        int64    `json:"review_count"`
+	AverageRatingGiven *float64 `json:"average_rating_given"`
+}
+
+type PublicUserResponse struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	JoinedAt    time.Time `json:"joined_at"`
+	Stats       UserStats `json:"stats"`
+}
+
+// UpdateProfileRequest only changes the fields that are present.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name,omitempty" binding:"omitempty,max=100"`
+	Bio         *string `json:"bio,omitempty" binding:"omitempty,max=1000"`
+	AvatarURL   *string `json:"avatar_url,omitempty" binding:"omitempty,max=500"`
+	Email       *string `json:"email,omitempty" binding:"omitempty,email"`
+}
+
+func toUserProfile(user models.User) UserProfileResponse {
+	return UserProfileResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		MFAEnabled:    user.TOTPEnabled,
+		JoinedAt:      user.CreatedAt,
+	}
+}
+
+func validAvatarURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// GetMyProfile godoc
+// @Summary Get own profile
+// @Description Get the account of the authenticated user
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} UserProfileResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /users/me [get]
+func GetMyProfile(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		c.JSON(http.StatusOK, toUserProfile(user))
+	}
+}
+
+// UpdateMyProfile godoc
+// @Summary Update own profile
+// @Description Change display name, bio, avatar URL or email of the authenticated user. Omitted fields are left unchanged. A new email address has to be verified again.
+// @Tags users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param profile body UpdateProfileRequest true "Profile fields"
+// @Success 200 {object} UserProfileResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me [patch]
+func UpdateMyProfile(db *gorm.DB, mailer mail.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := c.MustGet("user").(models.User)
+		updates := map[string]interface{}{}
+
+		if req.DisplayName != nil {
+			updates["display_name"] = *req.DisplayName
+		}
+		if req.Bio != nil {
+			updates["bio"] = *req.Bio
+		}
+		if req.AvatarURL != nil {
+			if !validAvatarURL(*req.AvatarURL) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "avatar_url must be an http or https URL"})
+				return
+			}
+			updates["avatar_url"] = *req.AvatarURL
+		}
+		if req.Email != nil && (user.Email == nil || *user.Email != *req.Email) {
+			var count int64
+			db.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", *req.Email, user.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+				return
+			}
+			updates["email"] = *req.Email
+			updates["email_verified_at"] = nil
+		}
+
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+				return
+			}
+		}
+
+		if err := db.First(&user, user.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+			return
+		}
+
+		if _, changed := updates["email"]; changed {
+			if err := auth.SendEmailVerification(db, mailer, user); err != nil {
+				log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+			}
+		}
+
+		c.JSON(http.StatusOK, toUserProfile(user))
+	}
+}
+
+// GetUserProfile godoc
+// @Summary Get public profile
+// @Description Get the public profile and review statistics of a user
+// @Tags users
+// @Produce json
+// @Param username path string true "Username"
+// @Success 200 {object} PublicUserResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{username} [get]
+func GetUserProfile(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		if err := db.Where("username = ? AND service_account = ?", c.Param("username"), false).First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var stats UserStats
+		result := db.Model(&models.Review{}).
+			Select("COUNT(*) as review_count, AVG(reviews.rating) as average_rating_given").
+			Where("reviews.user_id = ?", user.ID).
+			Where("reviews.movie_id NOT IN (SELECT id FROM movies WHERE deleted_at IS NOT NULL)").
+			Scan(&stats)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user stats"})
+			return
+		}
+
+		c.JSON(http.StatusOK, PublicUserResponse{
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarURL:   user.AvatarURL,
+			JoinedAt:    user.CreatedAt,
+			Stats:       stats,
+		})
+	}
+}
+
+type ExportedReview struct {
+	ID         uint      `json:"id"`
+	MovieID    uint      `json:"movie_id"`
+	MovieTitle string    `json:"movie_title"`
+	Rating     *float64  `json:"rating"`
+	Text       *string   `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type ExportedAPIKey struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     string     `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// UserExport is everything stored about a user, in one document.
+type UserExport struct {
+	ExportedAt time.Time           `json:"exported_at"`
+	Profile    UserProfileResponse `json:"profile"`
+	Reviews    []ExportedReview    `json:"reviews"`
+	APIKeys    []ExportedAPIKey    `json:"api_keys"`
+}
+
+// ExportMyData godoc
+// @Summary Export personal data
+// @Description Download the profile, reviews and API keys of the authenticated user as a JSON document
+// @Tags users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} UserExport
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/export [get]
+func ExportMyData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		export := UserExport{
+			ExportedAt: time.Now().UTC(),
+			Profile:    toUserProfile(user),
+			Reviews:    []ExportedReview{},
+			APIKeys:    []ExportedAPIKey{},
+		}
+
+		result := db.Model(&models.Review{}).
+			Select("reviews.id, reviews.movie_id, movies.title as movie_title, reviews.rating, reviews.text, reviews.created_at, reviews.updated_at").
+			Joins("LEFT JOIN movies ON movies.id = reviews.movie_id").
+			Where("reviews.user_id = ?", user.ID).
+			Order("reviews.id").
+			Scan(&export.Reviews)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+			return
+		}
+
+		var keys []models.APIKey
+		if err := db.Where("user_id = ?", user.ID).Order("id").Find(&keys).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+			return
+		}
+		for _, key := range keys {
+			export.APIKeys = append(export.APIKeys, ExportedAPIKey{
+				Name:       key.Name,
+				Prefix:     key.Prefix,
+				Scopes:     key.Scopes,
+				CreatedAt:  key.CreatedAt,
+				ExpiresAt:  key.ExpiresAt,
+				LastUsedAt: key.LastUsedAt,
+				RevokedAt:  key.RevokedAt,
+			})
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+user.Username+`-export.json"`)
+		c.JSON(http.StatusOK, export)
+	}
+}
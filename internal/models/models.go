@@ -1,14 +1,208 @@
 package models
 
 import (
+    "time"
+
     "gorm.io/gorm"
 )
 
+// UserRole grants access to catalog and moderation routes. Admins pass
+// every role check.
+type UserRole string
+
+const (
+    RoleUser      UserRole = "user"
+    RoleCurator   UserRole = "curator"
+    RoleModerator UserRole = "moderator"
+    RoleAdmin     UserRole = "admin"
+)
+
+func (r UserRole) Valid() bool {
+    switch r {
+    case RoleUser, RoleCurator, RoleModerator, RoleAdmin:
+        return true
+    }
+    return false
+}
+
+// DeletionMode records how a deleted account is purged. Anonymized accounts
+// keep a row without personal data so their reviews keep a valid author.
+type DeletionMode string
+
+const (
+    DeletionAnonymize  DeletionMode = "anonymize"
+    DeletionDelete     DeletionMode = "delete"
+    DeletionAnonymized DeletionMode = "anonymized"
+)
+
 type User struct {
     gorm.Model
     Username string `gorm:"unique"`
+    Email    *string `gorm:"size:254;unique"`
+    // EmailVerifiedAt is set once the user proved to own Email. Unverified
+    // accounts can read but not write.
+    EmailVerifiedAt *time.Time `gorm:"default:null"`
     Password string
-    Token    string `gorm:"unique"`
+    DisplayName string `gorm:"size:100"`
+    Bio         string `gorm:"type:text"`
+    AvatarURL   string `gorm:"size:500"`
+    Role     UserRole `gorm:"size:20;default:user"`
+    // ServiceAccount users have no password and authenticate with API keys only.
+    ServiceAccount bool `gorm:"default:false"`
+    // OIDCIssuer and OIDCSubject link the account to a single sign-on identity.
+    OIDCIssuer  *string `gorm:"column:oidc_issuer;size:255;uniqueIndex:idx_users_oidc_identity"`
+    OIDCSubject *string `gorm:"column:oidc_subject;size:255;uniqueIndex:idx_users_oidc_identity"`
+    // TOTPSecret is set when enrollment starts; login only asks for a code
+    // once TOTPEnabled is true. TOTPLastStep blocks code replay.
+    TOTPSecret   *string `gorm:"size:64"`
+    TOTPEnabled  bool    `gorm:"default:false"`
+    TOTPLastStep int64   `gorm:"default:0"`
+    // TokensRevokedAt invalidates every access token issued before it.
+    TokensRevokedAt *time.Time `gorm:"default:null"`
+    // DeletionMode is set when the user deletes the account and decides
+    // what happens to the reviews once the grace period is over.
+    DeletionMode DeletionMode `gorm:"size:20"`
+}
+
+// RefreshToken is stored hashed. Each refresh rotates the token within its
+// family; presenting an already rotated token revokes the whole family.
+type RefreshToken struct {
+    gorm.Model
+    UserID    uint       `gorm:"index"`
+    FamilyID  string     `gorm:"size:64;index"`
+    TokenHash string     `gorm:"size:64;unique"`
+    ExpiresAt time.Time
+    RevokedAt *time.Time `gorm:"default:null"`
+}
+
+// Session is one sign-in of a user: a refresh token family together with
+// where it was started and last used. Access tokens carry its ID in the
+// "sid" claim, so revoking the session ends them as well.
+type Session struct {
+    gorm.Model
+    UserID     uint       `gorm:"index"`
+    FamilyID   string     `gorm:"size:64;unique"`
+    IP         string     `gorm:"size:45"`
+    UserAgent  string     `gorm:"size:255"`
+    LastSeenAt time.Time
+    ExpiresAt  time.Time  `gorm:"index"`
+    RevokedAt  *time.Time `gorm:"default:null"`
+}
+
+type AuthEventType string
+
+const (
+    EventLoginSuccess    AuthEventType = "login_success"
+    EventLoginFailure    AuthEventType = "login_failure"
+    EventLoginThrottled  AuthEventType = "login_throttled"
+    EventMFAChallenge    AuthEventType = "mfa_challenge"
+    EventTokenIssued     AuthEventType = "token_issued"
+    EventTokenRefreshed  AuthEventType = "token_refreshed"
+    EventRefreshReuse    AuthEventType = "refresh_token_reuse"
+    EventTokenRejected   AuthEventType = "token_rejected"
+    EventLogout          AuthEventType = "logout"
+    EventSessionRevoked  AuthEventType = "session_revoked"
+    EventSessionsRevoked AuthEventType = "all_sessions_revoked"
+    EventResetMailFailed AuthEventType = "password_reset_mail_failed"
+)
+
+// AuthEvent is an append-only audit record of an authentication step.
+// UserID is empty when the username did not match an account.
+type AuthEvent struct {
+    ID        uint          `gorm:"primaryKey"`
+    CreatedAt time.Time     `gorm:"index"`
+    Event     AuthEventType `gorm:"size:40;index"`
+    UserID    *uint         `gorm:"index"`
+    Username  string        `gorm:"size:150;index"`
+    SessionID *uint
+    IP        string        `gorm:"size:45"`
+    UserAgent string        `gorm:"size:255"`
+    Detail    string        `gorm:"size:255"`
+}
+
+// APIKey is a long-lived credential of a user or service account. Only the
+// hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+    gorm.Model
+    UserID     uint       `gorm:"index"`
+    User       User
+    Name       string     `gorm:"size:100"`
+    Prefix     string     `gorm:"size:16"`
+    KeyHash    string     `gorm:"size:64;unique"`
+    Scopes     string     `gorm:"size:500"`
+    ExpiresAt  *time.Time `gorm:"default:null"`
+    LastUsedAt *time.Time `gorm:"default:null"`
+    RevokedAt  *time.Time `gorm:"default:null"`
+}
+
+// PasswordResetToken is a single-use, hashed token sent by email.
+type PasswordResetToken struct {
+    gorm.Model
+    UserID    uint       `gorm:"index"`
+    TokenHash string     `gorm:"size:64;unique"`
+    ExpiresAt time.Time
+    UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// EmailVerificationToken is a single-use, hashed token mailed to prove
+// ownership of Email. It is void once the account's address changes.
+type EmailVerificationToken struct {
+    gorm.Model
+    UserID    uint       `gorm:"index"`
+    Email     string     `gorm:"size:254"`
+    TokenHash string     `gorm:"size:64;unique"`
+    ExpiresAt time.Time
+    UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// LoginThrottle counts recent failed logins for one key, either
+// "user:<name>" or "ip:<address>". It lives in the database so every API
+// process sees the same counters.
+type LoginThrottle struct {
+    Key           string     `gorm:"column:throttle_key;primaryKey;size:150"`
+    Failures      int
+    LastFailureAt time.Time
+    LockedUntil   *time.Time `gorm:"default:null"`
+}
+
+// LockoutEvent records each time a key hit the lockout threshold.
+type LockoutEvent struct {
+    gorm.Model
+    Key         string     `gorm:"column:throttle_key;size:150;index"`
+    Username    string     `gorm:"size:50"`
+    IP          string     `gorm:"size:45"`
+    Failures    int
+    LockedUntil time.Time
+    UnlockedAt  *time.Time `gorm:"default:null"`
+    UnlockedBy  *uint      `gorm:"default:null"`
+}
+
+// OIDCLoginState carries the PKCE verifier and nonce of a pending single
+// sign-on login between the redirect to the provider and the callback.
+type OIDCLoginState struct {
+    State        string    `gorm:"primaryKey;size:64"`
+    CodeVerifier string    `gorm:"size:128"`
+    Nonce        string    `gorm:"size:64"`
+    ExpiresAt    time.Time `gorm:"index"`
+}
+
+func (OIDCLoginState) TableName() string {
+    return "oidc_login_states"
+}
+
+// RecoveryCode is a hashed single-use code that replaces a TOTP code when
+// the authenticator is lost.
+type RecoveryCode struct {
+    gorm.Model
+    UserID   uint       `gorm:"index"`
+    CodeHash string     `gorm:"size:64"`
+    UsedAt   *time.Time `gorm:"default:null"`
+}
+
+// RevokedToken lists access tokens killed before their expiry, by JWT ID.
+type RevokedToken struct {
+    JTI       string    `gorm:"primaryKey;size:64"`
+    ExpiresAt time.Time `gorm:"index"`
 }
 
 type Movie struct {
@@ -43,16 +237,57 @@ type Person struct {
     Name string `gorm:"size:100"`
 }
 
+// Role is a cast credit. Position is the billing order, starting at 1.
 type Role struct {
     gorm.Model
     MovieID   uint    `gorm:"index"`  
     PersonID  uint    `gorm:"index"`  
     Character string  `gorm:"size:100"`
+    Position  int     `gorm:"not null;default:0"`
     
     Movie     Movie   `gorm:"foreignKey:MovieID;references:ID"`
     Actor     Person  `gorm:"foreignKey:PersonID;references:ID"`
 }	
 
+// CrewDepartment groups the crew credits of a movie. Directors and writers
+// have their own tables.
+type CrewDepartment string
+
+const (
+    DepartmentProducer           CrewDepartment = "producer"
+    DepartmentComposer           CrewDepartment = "composer"
+    DepartmentCinematographer    CrewDepartment = "cinematographer"
+    DepartmentEditor             CrewDepartment = "editor"
+    DepartmentProductionDesigner CrewDepartment = "production_designer"
+    DepartmentCostumeDesigner    CrewDepartment = "costume_designer"
+    DepartmentCasting            CrewDepartment = "casting"
+    DepartmentSound              CrewDepartment = "sound"
+    DepartmentVisualEffects      CrewDepartment = "visual_effects"
+)
+
+func (d CrewDepartment) Valid() bool {
+    switch d {
+    case DepartmentProducer, DepartmentComposer, DepartmentCinematographer, DepartmentEditor,
+        DepartmentProductionDesigner, DepartmentCostumeDesigner, DepartmentCasting, DepartmentSound,
+        DepartmentVisualEffects:
+        return true
+    }
+    return false
+}
+
+// CrewCredit is a person working on a movie outside the cast. Job is an
+// optional title within the department, like "Executive Producer".
+type CrewCredit struct {
+    gorm.Model
+    MovieID    uint           `gorm:"index"`
+    PersonID   uint           `gorm:"index"`
+    Department CrewDepartment `gorm:"size:50;index"`
+    Job        string         `gorm:"size:100"`
+
+    Movie      Movie          `gorm:"foreignKey:MovieID;references:ID"`
+    Person     Person         `gorm:"foreignKey:PersonID;references:ID"`
+}
+
 type Country struct {
     gorm.Model
     Name string `gorm:"size:50;unique"`
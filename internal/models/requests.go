@@ -3,4 +3,69 @@ package models
 type LoginRequest struct {
     Username string `json:"username" example:"admin"`
     Password string `json:"password" example:"senha123"`
-}
\ No newline at end of file
+}
+
+type RefreshRequest struct {
+    RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+    RefreshToken string `json:"refresh_token,omitempty"`
+    All          bool   `json:"all,omitempty"`
+}
+
+type RoleRequest struct {
+    Role UserRole `json:"role" binding:"required" example:"curator"`
+}
+
+type CreateAPIKeyRequest struct {
+    Name          string   `json:"name" binding:"required,max=100" example:"nightly ingestion"`
+    Scopes        []string `json:"scopes" binding:"required,min=1" example:"movies:write"`
+    ExpiresInDays int      `json:"expires_in_days,omitempty" binding:"min=0" example:"90"`
+    // UserID creates the key for another account; admins only.
+    UserID *uint `json:"user_id,omitempty"`
+}
+
+type CreateServiceAccountRequest struct {
+    Username string   `json:"username" binding:"required,min=3,max=50" example:"ingestion-bot"`
+    Role     UserRole `json:"role,omitempty" example:"curator"`
+}
+
+type ChangePasswordRequest struct {
+    CurrentPassword string `json:"current_password" binding:"required"`
+    NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+    Email string `json:"email" binding:"required,email" example:"user@example.com"`
+}
+
+type VerifyEmailRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type PasswordResetConfirmRequest struct {
+    Token       string `json:"token" binding:"required"`
+    NewPassword string `json:"new_password" binding:"required"`
+}
+
+type UnlockRequest struct {
+    Username string `json:"username,omitempty" example:"user123"`
+    IP       string `json:"ip,omitempty" example:"203.0.113.7"`
+}
+
+type MFACodeRequest struct {
+    Code string `json:"code" binding:"required" example:"123456"`
+}
+
+type MFALoginRequest struct {
+    MFAToken string `json:"mfa_token" binding:"required"`
+    // Code is a TOTP code or one of the recovery codes.
+    Code string `json:"code" binding:"required" example:"123456"`
+}
+type DeleteAccountRequest struct {
+    // Reviews is "anonymize" to keep the reviews without the author's
+    // identity, or "delete" to remove them with the account.
+    Reviews  DeletionMode `json:"reviews" binding:"required,oneof=anonymize delete" example:"anonymize"`
+    Password string       `json:"password"`
+}
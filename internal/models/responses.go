@@ -1,9 +1,71 @@
 package models
 
+import "time"
+
 type TokenResponse struct {
-    Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+    Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+    RefreshToken string `json:"refresh_token" example:"3q2-7wX..."`
+    ExpiresIn    int64  `json:"expires_in" example:"900"`
+}
+
+// MFAChallengeResponse is returned by the login endpoint instead of tokens
+// when the account has two-factor authentication enabled.
+type MFAChallengeResponse struct {
+    MFARequired bool   `json:"mfa_required" example:"true"`
+    MFAToken    string `json:"mfa_token"`
+    ExpiresIn   int64  `json:"expires_in" example:"300"`
+}
+
+type TOTPEnrollmentResponse struct {
+    Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+    OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/Movie%20API:user123?secret=..."`
+}
+
+type RecoveryCodesResponse struct {
+    RecoveryCodes []string `json:"recovery_codes"`
 }
 
 type ErrorResponse struct {
     Error string `json:"error" example:"error message"`
-}	
\ No newline at end of file
+}	
+
+type SuccessResponse struct {
+    Message string `json:"message" example:"success message"`
+}
+
+type APIKeyResponse struct {
+    ID         uint       `json:"id"`
+    UserID     uint       `json:"user_id"`
+    Name       string     `json:"name"`
+    Prefix     string     `json:"prefix" example:"mk_3q2x7wX"`
+    Scopes     []string   `json:"scopes"`
+    CreatedAt  time.Time  `json:"created_at"`
+    ExpiresAt  *time.Time `json:"expires_at"`
+    LastUsedAt *time.Time `json:"last_used_at"`
+    RevokedAt  *time.Time `json:"revoked_at"`
+    // Key is only returned once, when the key is created.
+    Key string `json:"key,omitempty"`
+}
+
+type SessionResponse struct {
+    ID         uint      `json:"id"`
+    IP         string    `json:"ip"`
+    UserAgent  string    `json:"user_agent"`
+    CreatedAt  time.Time `json:"created_at"`
+    LastSeenAt time.Time `json:"last_seen_at"`
+    ExpiresAt  time.Time `json:"expires_at"`
+    // Current marks the session of the access token making the request.
+    Current bool `json:"current"`
+}
+
+type AuthEventResponse struct {
+    ID        uint          `json:"id"`
+    CreatedAt time.Time     `json:"created_at"`
+    Event     AuthEventType `json:"event" example:"login_success"`
+    UserID    *uint         `json:"user_id"`
+    Username  string        `json:"username"`
+    SessionID *uint         `json:"session_id"`
+    IP        string        `json:"ip"`
+    UserAgent string        `json:"user_agent"`
+    Detail    string        `json:"detail,omitempty"`
+}
@@ -0,0 +1,394 @@
+// Package search is the full-text index over movies and people.
+//
+// The index lives in SQLite full-text tables next to the data it indexes:
+// movies_fts holds the title, tagline, description and the names of the
+// cast and crew of every movie, and people_fts the name of every person.
+// Triggers on movies, people and the credit tables keep both in sync with
+// every write, whether it comes through gorm or plain SQL.
+//
+// FTS5 is used when the SQLite driver was built with it (go build -tags
+// sqlite_fts5). Otherwise the index falls back to FTS4, which is always
+// available, and BM25 is computed from matchinfo instead of by SQLite.
+package search
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// ErrEmptyQuery is returned for a query without any searchable word.
+var ErrEmptyQuery = errors.New("query has no searchable words")
+
+const (
+	// maxQueryTerms bounds the size of the MATCH expression.
+	maxQueryTerms = 10
+
+	// Markers around matches; the text is HTML-escaped before they are
+	// replaced with <mark> tags.
+	markStart = "\x02"
+	markEnd   = "\x03"
+	ellipsis  = "…"
+)
+
+// Weights of the movies_fts columns in the ranking: a match in the title
+// counts most, then one in the cast, tagline and description.
+var movieWeights = []float64{10, 2, 1, 4}
+
+// MovieHit is a movie matching a search. Title and Snippet are HTML with
+// the matched words in <mark> tags.
+type MovieHit struct {
+	ID      uint    `json:"id"`
+	Title   string  `json:"title"`
+	Year    int     `json:"year"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// PersonHit is a person matching a search. Name is HTML with the matched
+// words in <mark> tags.
+type PersonHit struct {
+	ID    uint    `json:"id"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Results are the hits of a search grouped by entity type, best first.
+type Results struct {
+	Movies []MovieHit  `json:"movies"`
+	People []PersonHit `json:"people"`
+}
+
+// Index searches the full-text tables.
+type Index struct {
+	db   *gorm.DB
+	fts5 bool
+}
+
+// refreshMovies returns the statements that reindex the movies whose IDs
+// are selected by the SQL condition ids, e.g. "= NEW.movie_id".
+func refreshMovies(ids string) string {
+	return fmt.Sprintf(`DELETE FROM movies_fts WHERE rowid %[1]s;
+	INSERT INTO movies_fts (rowid, title, people, tagline, description)
+	SELECT movies.id, movies.title,
+		COALESCE((SELECT group_concat(people.name, ' ') FROM people
+			WHERE people.deleted_at IS NULL AND people.id IN (
+				SELECT person_id FROM movie_actors WHERE movie_id = movies.id
+				UNION SELECT person_id FROM movie_directors WHERE movie_id = movies.id
+				UNION SELECT person_id FROM movie_writers WHERE movie_id = movies.id)), ''),
+		COALESCE(movies.tagline, ''), COALESCE(movies.description, '')
+	FROM movies WHERE movies.id %[1]s AND movies.deleted_at IS NULL;`, ids)
+}
+
+// refreshPerson returns the statements that reindex the person with the ID
+// id.
+func refreshPerson(id string) string {
+	return fmt.Sprintf(`DELETE FROM people_fts WHERE rowid = %[1]s;
+	INSERT INTO people_fts (rowid, name)
+	SELECT id, name FROM people WHERE id = %[1]s AND deleted_at IS NULL;`, id)
+}
+
+const moviesOfPerson = "IN (SELECT movie_id FROM movie_actors WHERE person_id = %[1]s " +
+	"UNION SELECT movie_id FROM movie_directors WHERE person_id = %[1]s " +
+	"UNION SELECT movie_id FROM movie_writers WHERE person_id = %[1]s)"
+
+func triggers() []string {
+	stmts := []string{
+		`CREATE TRIGGER IF NOT EXISTS movies_fts_insert AFTER INSERT ON movies BEGIN ` +
+			refreshMovies("= NEW.id") + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS movies_fts_update AFTER UPDATE OF title, tagline, description, deleted_at ON movies BEGIN ` +
+			refreshMovies("= NEW.id") + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS movies_fts_delete AFTER DELETE ON movies BEGIN
+			DELETE FROM movies_fts WHERE rowid = OLD.id; END`,
+		`CREATE TRIGGER IF NOT EXISTS people_fts_insert AFTER INSERT ON people BEGIN ` +
+			refreshPerson("NEW.id") + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS people_fts_update AFTER UPDATE OF name, deleted_at ON people BEGIN ` +
+			refreshPerson("NEW.id") + refreshMovies(fmt.Sprintf(moviesOfPerson, "NEW.id")) + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS people_fts_delete AFTER DELETE ON people BEGIN
+			DELETE FROM people_fts WHERE rowid = OLD.id; ` +
+			refreshMovies(fmt.Sprintf(moviesOfPerson, "OLD.id")) + ` END`,
+	}
+	for _, credits := range []string{"movie_actors", "movie_directors", "movie_writers"} {
+		stmts = append(stmts,
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_fts_insert AFTER INSERT ON %[1]s BEGIN `, credits)+
+				refreshMovies("= NEW.movie_id")+` END`,
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_fts_delete AFTER DELETE ON %[1]s BEGIN `, credits)+
+				refreshMovies("= OLD.movie_id")+` END`,
+		)
+	}
+	return stmts
+}
+
+// Setup creates the full-text tables and their triggers if they do not
+// exist yet and indexes the existing data when the tables are new. Run it
+// after the schema migration.
+func Setup(db *gorm.DB) (*Index, error) {
+	var existing string
+	db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'movies_fts'").Scan(&existing)
+
+	ix := &Index{db: db}
+	if existing != "" {
+		ix.fts5 = strings.Contains(strings.ToLower(existing), "fts5")
+	} else {
+		ix.fts5 = db.Exec("CREATE VIRTUAL TABLE movies_fts USING fts5(title, people, tagline, description)").Error == nil
+		module := "fts5(name)"
+		if !ix.fts5 {
+			if err := db.Exec("CREATE VIRTUAL TABLE movies_fts USING fts4(title, people, tagline, description, tokenize=unicode61)").Error; err != nil {
+				return nil, fmt.Errorf("create movies_fts: %w", err)
+			}
+			module = "fts4(name, tokenize=unicode61)"
+		}
+		if err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS people_fts USING " + module).Error; err != nil {
+			return nil, fmt.Errorf("create people_fts: %w", err)
+		}
+	}
+
+	for _, stmt := range triggers() {
+		if err := db.Exec(stmt).Error; err != nil {
+			return nil, fmt.Errorf("create search triggers: %w", err)
+		}
+	}
+
+	if existing == "" {
+		if err := ix.Rebuild(); err != nil {
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+// FTS5 reports whether the index uses FTS5.
+func (ix *Index) FTS5() bool {
+	return ix.fts5
+}
+
+// Rebuild reindexes every movie and person.
+func (ix *Index) Rebuild() error {
+	return ix.db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			"DELETE FROM movies_fts",
+			"DELETE FROM people_fts",
+			"INSERT INTO people_fts (rowid, name) SELECT id, name FROM people WHERE deleted_at IS NULL",
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("rebuild search index: %w", err)
+			}
+		}
+		for _, stmt := range strings.SplitAfter(refreshMovies("IS NOT NULL"), ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("rebuild search index: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// matchQuery turns user input into a MATCH expression that requires every
+// word, each as a prefix. Everything but letters and digits is dropped, so
+// the input cannot use the query syntax.
+func matchQuery(q string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", ErrEmptyQuery
+	}
+	if len(words) > maxQueryTerms {
+		words = words[:maxQueryTerms]
+	}
+	for i, word := range words {
+		words[i] = word + "*"
+	}
+	return strings.Join(words, " "), nil
+}
+
+// markup escapes text and turns the match markers into <mark> tags.
+func markup(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, markStart, "<mark>")
+	return strings.ReplaceAll(text, markEnd, "</mark>")
+}
+
+// Search returns up to limit movies and limit people matching q.
+func (ix *Index) Search(q string, limit int) (Results, error) {
+	match, err := matchQuery(q)
+	if err != nil {
+		return Results{}, err
+	}
+
+	results := Results{Movies: []MovieHit{}, People: []PersonHit{}}
+	if ix.fts5 {
+		err = ix.searchFTS5(match, limit, &results)
+	} else {
+		err = ix.searchFTS4(match, limit, &results)
+	}
+	if err != nil {
+		return Results{}, err
+	}
+
+	for i := range results.Movies {
+		results.Movies[i].Title = markup(results.Movies[i].Title)
+		results.Movies[i].Snippet = markup(results.Movies[i].Snippet)
+	}
+	for i := range results.People {
+		results.People[i].Name = markup(results.People[i].Name)
+	}
+	return results, nil
+}
+
+func (ix *Index) searchFTS5(match string, limit int, results *Results) error {
+	weights := make([]string, len(movieWeights))
+	for i, w := range movieWeights {
+		weights[i] = fmt.Sprint(w)
+	}
+
+	err := ix.db.Raw(fmt.Sprintf(`SELECT movies.id, movies.year,
+			highlight(movies_fts, 0, ?, ?) AS title,
+			snippet(movies_fts, -1, ?, ?, ?, 16) AS snippet,
+			-bm25(movies_fts, %s) AS score
+		FROM movies_fts JOIN movies ON movies.id = movies_fts.rowid
+		WHERE movies_fts MATCH ?
+		ORDER BY score DESC, movies.id LIMIT ?`, strings.Join(weights, ", ")),
+		markStart, markEnd, markStart, markEnd, ellipsis, match, limit).
+		Scan(&results.Movies).Error
+	if err != nil {
+		return fmt.Errorf("search movies: %w", err)
+	}
+
+	err = ix.db.Raw(`SELECT rowid AS id, highlight(people_fts, 0, ?, ?) AS name, -bm25(people_fts) AS score
+		FROM people_fts WHERE people_fts MATCH ?
+		ORDER BY score DESC, rowid LIMIT ?`,
+		markStart, markEnd, match, limit).
+		Scan(&results.People).Error
+	if err != nil {
+		return fmt.Errorf("search people: %w", err)
+	}
+	return nil
+}
+
+// matchInfo is a decoded matchinfo(..., 'pcnalx') blob.
+type matchInfo []uint32
+
+func decodeMatchInfo(blob []byte) (matchInfo, error) {
+	if len(blob)%4 != 0 || len(blob) < 12 {
+		return nil, fmt.Errorf("invalid matchinfo of %d bytes", len(blob))
+	}
+	info := make(matchInfo, len(blob)/4)
+	for i := range info {
+		info[i] = binary.NativeEndian.Uint32(blob[i*4:])
+	}
+	phrases, cols := int(info[0]), int(info[1])
+	if len(info) != 3+2*cols+3*phrases*cols {
+		return nil, fmt.Errorf("invalid matchinfo of %d values", len(info))
+	}
+	return info, nil
+}
+
+// bm25 ranks a row like FTS5's bm25() does, with k1 = 1.2 and b = 0.75,
+// from the statistics FTS4 provides. weights holds one weight per column;
+// missing weights count as 1.
+func (info matchInfo) bm25(weights []float64) float64 {
+	const k1, b = 1.2, 0.75
+
+	phrases, cols := int(info[0]), int(info[1])
+	rows := float64(info[2])
+	avgLen := info[3 : 3+cols]
+	rowLen := info[3+cols : 3+2*cols]
+	hits := info[3+2*cols:]
+
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < cols; c++ {
+			x := hits[3*(p*cols+c):]
+			tf, docs := float64(x[0]), float64(x[2])
+			if tf == 0 {
+				continue
+			}
+			// Like FTS5, a term in more than half the rows still counts a
+			// little instead of negatively.
+			idf := math.Max(math.Log((rows-docs+0.5)/(docs+0.5)), 1e-6)
+			norm := 1.0
+			if avgLen[c] > 0 {
+				norm = 1 - b + b*float64(rowLen[c])/float64(avgLen[c])
+			}
+			weight := 1.0
+			if c < len(weights) {
+				weight = weights[c]
+			}
+			score += weight * idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+	return score
+}
+
+func (ix *Index) searchFTS4(match string, limit int, results *Results) error {
+	var movies []struct {
+		MovieHit
+		MatchInfo []byte
+	}
+	err := ix.db.Raw(`SELECT movies.id, movies.year,
+			snippet(movies_fts, ?, ?, ?, 0, 64) AS title,
+			snippet(movies_fts, ?, ?, ?, -1, 16) AS snippet,
+			matchinfo(movies_fts, 'pcnalx') AS match_info
+		FROM movies_fts JOIN movies ON movies.id = movies_fts.rowid
+		WHERE movies_fts MATCH ?`,
+		markStart, markEnd, ellipsis, markStart, markEnd, ellipsis, match).
+		Scan(&movies).Error
+	if err != nil {
+		return fmt.Errorf("search movies: %w", err)
+	}
+	for _, m := range movies {
+		info, err := decodeMatchInfo(m.MatchInfo)
+		if err != nil {
+			return err
+		}
+		m.Score = info.bm25(movieWeights)
+		results.Movies = append(results.Movies, m.MovieHit)
+	}
+	sort.SliceStable(results.Movies, func(i, j int) bool {
+		a, b := results.Movies[i], results.Movies[j]
+		return a.Score > b.Score || a.Score == b.Score && a.ID < b.ID
+	})
+	if len(results.Movies) > limit {
+		results.Movies = results.Movies[:limit]
+	}
+
+	var people []struct {
+		PersonHit
+		MatchInfo []byte
+	}
+	err = ix.db.Raw(`SELECT rowid AS id, snippet(people_fts, ?, ?, ?, 0, 64) AS name,
+			matchinfo(people_fts, 'pcnalx') AS match_info
+		FROM people_fts WHERE people_fts MATCH ?`,
+		markStart, markEnd, ellipsis, match).
+		Scan(&people).Error
+	if err != nil {
+		return fmt.Errorf("search people: %w", err)
+	}
+	for _, p := range people {
+		info, err := decodeMatchInfo(p.MatchInfo)
+		if err != nil {
+			return err
+		}
+		p.Score = info.bm25(nil)
+		results.People = append(results.People, p.PersonHit)
+	}
+	sort.SliceStable(results.People, func(i, j int) bool {
+		a, b := results.People[i], results.People[j]
+		return a.Score > b.Score || a.Score == b.Score && a.ID < b.ID
+	})
+	if len(results.People) > limit {
+		results.People = results.People[:limit]
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package search
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// maxCandidates is how many entries sharing the most trigrams with the
+	// query are scored by edit distance.
+	maxCandidates = 300
+	// maxSuggestQuery bounds the query length, which keeps the edit
+	// distance computation cheap.
+	maxSuggestQuery = 64
+	// compactMin is how many replaced entries may pile up in entries and
+	// the trigram lists before they are dropped, once they also make up a
+	// quarter of all entries.
+	compactMin = 1024
+)
+
+// Suggestion is an autocomplete match. Distance is the number of typos
+// between the query and the closest prefix of a word sequence in Text, and
+// Popularity the number of reviews of a movie or credits of a person.
+type Suggestion struct {
+	Type       string `json:"type"`
+	ID         uint   `json:"id"`
+	Text       string `json:"text"`
+	Year       int    `json:"year,omitempty"`
+	Distance   int    `json:"distance"`
+	Popularity int    `json:"popularity"`
+}
+
+type suggestEntry struct {
+	Suggestion
+	norm    string
+	words   []int // start offsets of the words in norm
+	deleted bool
+}
+
+type entryKey struct {
+	kind string
+	id   uint
+}
+
+// Suggester is an in-memory trigram index of movie titles and person names
+// for typo-tolerant autocompletion. Load fills it and Watch keeps it up to
+// date with writes made through gorm. It is safe for concurrent use.
+type Suggester struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	entries  []*suggestEntry
+	byKey    map[entryKey]int
+	trigrams map[string][]int32
+	// dead counts the entries marked deleted.
+	dead int
+
+	rebuild chan struct{}
+}
+
+// NewSuggester returns an empty index over db.
+func NewSuggester(db *gorm.DB) *Suggester {
+	return &Suggester{
+		db:       db,
+		byKey:    map[entryKey]int{},
+		trigrams: map[string][]int32{},
+		rebuild:  make(chan struct{}, 1),
+	}
+}
+
+// normalize lowercases s, drops apostrophes and turns every other run of
+// non-alphanumeric characters into one space.
+func normalize(s string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == '\'' || r == '’':
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case !space:
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// trigramsOf returns the distinct trigrams of the words of a normalized
+// string. Words are padded so short words and word starts get trigrams.
+func trigramsOf(norm string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	for _, word := range strings.Fields(norm) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			gram := string(runes[i : i+3])
+			if !seen[gram] {
+				seen[gram] = true
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return grams
+}
+
+func wordStarts(norm string) []int {
+	starts := []int{0}
+	runes := []rune(norm)
+	for i, r := range runes {
+		if r == ' ' && i+1 < len(runes) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// prefixDistance is the edit distance between q and the closest prefix of
+// text.
+func prefixDistance(q, text []rune, row []int) int {
+	// row[j] is the distance between the current prefix of q and text[:j].
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(q); i++ {
+		diag := row[0]
+		row[0] = i
+		for j := 1; j <= len(text); j++ {
+			cost := 1
+			if q[i-1] == text[j-1] {
+				cost = 0
+			}
+			up := row[j]
+			row[j] = min(row[j]+1, row[j-1]+1, diag+cost)
+			diag = up
+		}
+	}
+	best := row[0]
+	for _, d := range row[:len(text)+1] {
+		best = min(best, d)
+	}
+	return best
+}
+
+// maxDistance is the number of typos tolerated in a query of n letters.
+func maxDistance(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	case n <= 9:
+		return 2
+	}
+	return 3
+}
+
+// put adds, replaces or, for a deleted entry, removes the entry of a movie
+// or person.
+func (s *Suggester) put(e *suggestEntry) {
+	key := entryKey{e.Type, e.ID}
+	old, exists := s.byKey[key]
+	if !e.deleted {
+		e.norm = normalize(e.Text)
+		e.words = wordStarts(e.norm)
+		// Only the text is indexed, so an entry whose text is the same,
+		// like a movie that got a review, is updated in place.
+		if exists && s.entries[old].norm == e.norm {
+			s.entries[old] = e
+			return
+		}
+	}
+
+	if exists {
+		s.entries[old].deleted = true
+		delete(s.byKey, key)
+		s.dead++
+	}
+	if !e.deleted {
+		s.add(e)
+	}
+	if s.dead >= compactMin && s.dead*4 >= len(s.entries) {
+		s.compact()
+	}
+}
+
+// add appends a normalized entry and indexes its trigrams.
+func (s *Suggester) add(e *suggestEntry) {
+	idx := int32(len(s.entries))
+	s.entries = append(s.entries, e)
+	s.byKey[entryKey{e.Type, e.ID}] = int(idx)
+	for _, gram := range trigramsOf(e.norm) {
+		s.trigrams[gram] = append(s.trigrams[gram], idx)
+	}
+}
+
+// compact rebuilds the entries and trigram lists without the deleted
+// entries.
+func (s *Suggester) compact() {
+	entries := s.entries
+	s.entries = make([]*suggestEntry, 0, len(entries)-s.dead)
+	s.byKey = make(map[entryKey]int, len(entries)-s.dead)
+	s.trigrams = make(map[string][]int32, len(s.trigrams))
+	s.dead = 0
+	for _, e := range entries {
+		if !e.deleted {
+			s.add(e)
+		}
+	}
+}
+
+type suggestRow struct {
+	ID         uint
+	Text       string
+	Year       int
+	Popularity int
+}
+
+const (
+	movieSuggestQuery = `SELECT movies.id, movies.title AS text, movies.year,
+		(SELECT COUNT(*) FROM reviews WHERE reviews.movie_id = movies.id AND reviews.deleted_at IS NULL) AS popularity
+		FROM movies WHERE movies.deleted_at IS NULL`
+	personSuggestQuery = `SELECT people.id, people.name AS text,
+		(SELECT COUNT(*) FROM movie_actors WHERE person_id = people.id)
+		+ (SELECT COUNT(*) FROM movie_directors WHERE person_id = people.id)
+		+ (SELECT COUNT(*) FROM movie_writers WHERE person_id = people.id)
+		+ (SELECT COUNT(*) FROM crew_credits WHERE person_id = people.id AND crew_credits.deleted_at IS NULL) AS popularity
+		FROM people WHERE people.deleted_at IS NULL`
+)
+
+// Load rebuilds the index from the database.
+func (s *Suggester) Load() error {
+	var movies, people []suggestRow
+	if err := s.db.Raw(movieSuggestQuery).Scan(&movies).Error; err != nil {
+		return err
+	}
+	if err := s.db.Raw(personSuggestQuery).Scan(&people).Error; err != nil {
+		return err
+	}
+
+	fresh := NewSuggester(s.db)
+	for _, row := range movies {
+		fresh.put(&suggestEntry{Suggestion: Suggestion{Type: "movie", ID: row.ID, Text: row.Text, Year: row.Year, Popularity: row.Popularity}})
+	}
+	for _, row := range people {
+		fresh.put(&suggestEntry{Suggestion: Suggestion{Type: "person", ID: row.ID, Text: row.Text, Popularity: row.Popularity}})
+	}
+
+	s.mu.Lock()
+	s.entries, s.byKey, s.trigrams, s.dead = fresh.entries, fresh.byKey, fresh.trigrams, fresh.dead
+	s.mu.Unlock()
+	return nil
+}
+
+// refresh reloads single entries from the committed rows.
+func (s *Suggester) refresh(kind string, ids []uint) error {
+	query := movieSuggestQuery + " AND movies.id IN ?"
+	if kind == "person" {
+		query = personSuggestQuery + " AND people.id IN ?"
+	}
+	var rows []suggestRow
+	if err := s.db.Raw(query, ids).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	found := map[uint]bool{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		found[row.ID] = true
+		s.put(&suggestEntry{Suggestion: Suggestion{Type: kind, ID: row.ID, Text: row.Text, Year: row.Year, Popularity: row.Popularity}})
+	}
+	for _, id := range ids {
+		if !found[id] {
+			s.put(&suggestEntry{Suggestion: Suggestion{Type: kind, ID: id}, deleted: true})
+		}
+	}
+	return nil
+}
+
+// Watch registers gorm callbacks that update the index after movies,
+// people, reviews and credits are written. Writes that cannot be traced to single
+// rows, like updates by condition, reload the whole index in the
+// background. Watch also wraps the connection pool of the database, so
+// writes inside a transaction update the index once it commits and not at
+// all when it rolls back.
+func (s *Suggester) Watch() error {
+	go func() {
+		for range s.rebuild {
+			if err := s.Load(); err != nil {
+				log.Printf("search: failed to reload autocomplete index: %v", err)
+			}
+		}
+	}()
+
+	pool := &watchedPool{ConnPool: s.db.ConnPool, s: s}
+	s.db.ConnPool = pool
+	s.db.Statement.ConnPool = pool
+
+	callback := s.db.Callback()
+	if err := callback.Create().After("gorm:create").Register("search:suggest_create", s.afterWrite); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Register("search:suggest_update", s.afterWrite); err != nil {
+		return err
+	}
+	return callback.Delete().After("gorm:delete").Register("search:suggest_delete", s.afterWrite)
+}
+
+func (s *Suggester) scheduleLoad() {
+	select {
+	case s.rebuild <- struct{}{}:
+	default:
+	}
+}
+
+// fieldValues collects the non-zero values of field from the rows the
+// statement wrote. ok is false when a row has none.
+func fieldValues(db *gorm.DB, field *schema.Field) (values []uint, ok bool) {
+	rv := db.Statement.ReflectValue
+	collect := func(v interface{}, zero bool) bool {
+		id, isUint := v.(uint)
+		if zero || !isUint {
+			return false
+		}
+		values = append(values, id)
+		return true
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return values, collect(field.ValueOf(context.Background(), rv))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !collect(field.ValueOf(context.Background(), rv.Index(i))) {
+				return nil, false
+			}
+		}
+		return values, len(values) > 0
+	}
+	return nil, false
+}
+
+func (s *Suggester) afterWrite(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.RowsAffected == 0 {
+		return
+	}
+
+	var kind string
+	var field *schema.Field
+	switch db.Statement.Schema.Table {
+	case "movies":
+		kind, field = "movie", db.Statement.Schema.PrioritizedPrimaryField
+	case "people":
+		kind, field = "person", db.Statement.Schema.PrioritizedPrimaryField
+	case "reviews":
+		kind, field = "movie", db.Statement.Schema.LookUpField("MovieID")
+	case "movie_actors", "movie_directors", "movie_writers", "crew_credits":
+		kind, field = "person", db.Statement.Schema.LookUpField("PersonID")
+	default:
+		return
+	}
+	if field == nil {
+		return
+	}
+
+	ids, ok := fieldValues(db, field)
+	tx, inTx := db.Statement.ConnPool.(*watchedTx)
+	switch {
+	case inTx && !ok:
+		tx.queueLoad()
+		return
+	case inTx:
+		tx.queue(kind, ids)
+		return
+	case !ok:
+		s.scheduleLoad()
+		return
+	}
+	if err := s.refresh(kind, ids); err != nil {
+		log.Printf("search: failed to update autocomplete index: %v", err)
+		s.scheduleLoad()
+	}
+}
+
+// Suggest returns up to limit movies and people whose title or name starts
+// a word with q, allowing for typos. Closer matches come first, then more
+// popular ones.
+func (s *Suggester) Suggest(q string, limit int) ([]Suggestion, error) {
+	norm := normalize(q)
+	if norm == "" {
+		return nil, ErrEmptyQuery
+	}
+	query := []rune(norm)
+	if len(query) > maxSuggestQuery {
+		query = query[:maxSuggestQuery]
+		norm = string(query)
+	}
+	tolerance := maxDistance(len(query))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Count the trigrams every entry shares with the query. A typo
+	// changes at most three trigrams, and the closing trigram of the last
+	// word is missing when the query is a prefix, so entries sharing fewer
+	// cannot match.
+	grams := trigramsOf(norm)
+	needed := max(len(grams)-3*tolerance-1, 1)
+	counts := make([]uint16, len(s.entries))
+	var touched []int32
+	for _, gram := range grams {
+		for _, idx := range s.trigrams[gram] {
+			if counts[idx] == 0 {
+				touched = append(touched, idx)
+			}
+			counts[idx]++
+		}
+	}
+
+	// Score the entries sharing the most trigrams, the more popular first
+	// among those sharing as many.
+	buckets := make([][]int32, len(grams)+1)
+	for _, idx := range touched {
+		if n := int(counts[idx]); n >= needed && !s.entries[idx].deleted {
+			buckets[n] = append(buckets[n], idx)
+		}
+	}
+	var candidates []int32
+	for n := len(grams); n >= needed && len(candidates) < maxCandidates; n-- {
+		bucket := buckets[n]
+		if room := maxCandidates - len(candidates); len(bucket) > room {
+			sort.Slice(bucket, func(i, j int) bool {
+				return s.entries[bucket[i]].Popularity > s.entries[bucket[j]].Popularity
+			})
+			bucket = bucket[:room]
+		}
+		candidates = append(candidates, bucket...)
+	}
+
+	var matches []Suggestion
+	var row []int
+	for _, idx := range candidates {
+		e := s.entries[idx]
+		text := []rune(e.norm)
+		if cap(row) < len(text)+1 {
+			row = make([]int, len(text)+1)
+		}
+		best := tolerance + 1
+		for _, start := range e.words {
+			best = min(best, prefixDistance(query, text[start:], row[:len(text)-start+1]))
+		}
+		if best <= tolerance {
+			match := e.Suggestion
+			match.Distance = best
+			matches = append(matches, match)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if a.Popularity != b.Popularity {
+			return a.Popularity > b.Popularity
+		}
+		if len(a.Text) != len(b.Text) {
+			return len(a.Text) < len(b.Text)
+		}
+		return a.Type < b.Type || a.Type == b.Type && a.ID < b.ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	if matches == nil {
+		matches = []Suggestion{}
+	}
+	return matches, nil
+}
@@ -0,0 +1,121 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// watchedPool wraps the connection pool of the watched database so that
+// index updates made by writes inside a transaction wait for its commit.
+type watchedPool struct {
+	gorm.ConnPool
+	s *Suggester
+}
+
+// GetDBConn lets gorm reach the underlying *sql.DB.
+func (p *watchedPool) GetDBConn() (*sql.DB, error) {
+	switch pool := p.ConnPool.(type) {
+	case *sql.DB:
+		return pool, nil
+	case gorm.GetDBConnector:
+		return pool.GetDBConn()
+	}
+	return nil, errors.New("search: connection pool has no *sql.DB")
+}
+
+// BeginTx starts a transaction that collects index updates until it
+// commits.
+func (p *watchedPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx gorm.ConnPool
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		sqlTx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		tx = sqlTx
+	case gorm.ConnPoolBeginner:
+		poolTx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		tx = poolTx
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	return &watchedTx{ConnPool: tx, s: p.s}, nil
+}
+
+// pendingRefresh is an index update waiting for its transaction.
+type pendingRefresh struct {
+	kind string
+	ids  []uint
+}
+
+type watchedTx struct {
+	gorm.ConnPool
+	s *Suggester
+
+	mu      sync.Mutex
+	pending []pendingRefresh
+	reload  bool
+}
+
+func (tx *watchedTx) queue(kind string, ids []uint) {
+	tx.mu.Lock()
+	tx.pending = append(tx.pending, pendingRefresh{kind, ids})
+	tx.mu.Unlock()
+}
+
+func (tx *watchedTx) queueLoad() {
+	tx.mu.Lock()
+	tx.reload = true
+	tx.mu.Unlock()
+}
+
+// Commit commits the transaction and then applies its index updates,
+// which read the committed rows.
+func (tx *watchedTx) Commit() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	if err := committer.Commit(); err != nil {
+		return err
+	}
+
+	tx.mu.Lock()
+	pending, reload := tx.pending, tx.reload
+	tx.pending, tx.reload = nil, false
+	tx.mu.Unlock()
+
+	if reload {
+		tx.s.scheduleLoad()
+		return nil
+	}
+	for _, p := range pending {
+		if err := tx.s.refresh(p.kind, p.ids); err != nil {
+			log.Printf("search: failed to update autocomplete index: %v", err)
+			tx.s.scheduleLoad()
+			return nil
+		}
+	}
+	return nil
+}
+
+// Rollback rolls the transaction back and drops its index updates.
+func (tx *watchedTx) Rollback() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	tx.mu.Lock()
+	tx.pending, tx.reload = nil, false
+	tx.mu.Unlock()
+	return committer.Rollback()
+}